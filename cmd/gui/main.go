@@ -5,9 +5,11 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
@@ -17,40 +19,18 @@ import (
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/widget"
 
+	"immich-uploader/internal/config"
 	"immich-uploader/internal/uploader"
 )
 
-type Config struct {
-	BaseURL       string        `json:"baseUrl"`
-	APIKey        string        `json:"apiKey"`
-	Root          string        `json:"root"`
-	Deep          bool          `json:"deep"`
-	Checksum      bool          `json:"checksum"`
-	BatchSize     int           `json:"batchSize"`
-	Workers       int           `json:"workers"`
-	SmallestFirst bool          `json:"smallestFirst"`
-	DedupeAdd     bool          `json:"dedupeAdd"`
-	IgnoreDir     string        `json:"ignoreDir"`
-	Timeout       time.Duration `json:"timeout"`
-}
-
-func defaultConfig() Config {
-	return Config{
-		BaseURL:       "http://localhost:2283/api",
-		APIKey:        "",
-		Root:          "",
-		Deep:          true,
-		Checksum:      true,
-		BatchSize:     200,
-		Workers:       4,
-		SmallestFirst: true,
-		DedupeAdd:     true,
-		IgnoreDir:     "ignore",
-		Timeout:       5 * time.Minute,
-	}
-}
+// guiKeys are the settings edited in the window. Those the user changed are
+// saved back on start; the rest may come from the environment and stay out
+// of the file. The API key itself is never saved, like the password: a key
+// file or key command keeps it out of the config file.
+var guiKeys = []string{"immich", "key-file", "key-command", "email", "shared-link", "root", "deep", "checksum", "smallest-first", "dedupe-add", "workers", "batch", "timeout", "ignore-dir"}
 
-func configPath() string {
+// legacyConfigPath is where older versions kept the GUI settings as JSON.
+func legacyConfigPath() string {
 	dir, err := os.UserConfigDir()
 	if err != nil {
 		return "immich-uploader.json"
@@ -58,23 +38,34 @@ func configPath() string {
 	return filepath.Join(dir, "immich-uploader", "config.json")
 }
 
-func loadConfig() Config {
-	cfg := defaultConfig()
-	b, err := os.ReadFile(configPath())
-	if err != nil {
-		return cfg
-	}
-	_ = json.Unmarshal(b, &cfg)
-	return cfg
-}
-
-func saveConfig(cfg Config) error {
-	p := configPath()
-	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
-		return err
+// loadConfig loads the shared config, importing the old JSON settings once
+// if there is no config file yet. The old file is removed once its settings
+// are saved; its API key is not saved, only returned for this session.
+func loadConfig() (_ config.Config, legacyKey string, _ error) {
+	if _, err := os.Stat(config.DefaultPath()); errors.Is(err, os.ErrNotExist) && os.Getenv(config.EnvConfig) == "" {
+		if b, err := os.ReadFile(legacyConfigPath()); err == nil {
+			cfg := config.Defaults()
+			legacy := struct {
+				BaseURL       *string        `json:"baseUrl"`
+				APIKey        *string        `json:"apiKey"`
+				Root          *string        `json:"root"`
+				Deep          *bool          `json:"deep"`
+				Checksum      *bool          `json:"checksum"`
+				BatchSize     *int           `json:"batchSize"`
+				Workers       *int           `json:"workers"`
+				SmallestFirst *bool          `json:"smallestFirst"`
+				DedupeAdd     *bool          `json:"dedupeAdd"`
+				IgnoreDir     *string        `json:"ignoreDir"`
+				Timeout       *time.Duration `json:"timeout"`
+			}{&cfg.BaseURL, &cfg.APIKey, &cfg.Root, &cfg.Deep, &cfg.Checksum, &cfg.BatchSize, &cfg.Workers, &cfg.SmallestFirst, &cfg.DedupeAdd, &cfg.IgnoreDir, &cfg.Timeout}
+			if json.Unmarshal(b, &legacy) == nil && config.Save("", "", cfg, guiKeys...) == nil {
+				legacyKey = cfg.APIKey
+				_ = os.Remove(legacyConfigPath())
+			}
+		}
 	}
-	b, _ := json.MarshalIndent(cfg, "", "  ")
-	return os.WriteFile(p, b, 0o600)
+	cfg, err := config.Load("", "")
+	return cfg, legacyKey, err
 }
 
 func main() {
@@ -82,12 +73,33 @@ func main() {
 	w := a.NewWindow("Immich Uploader")
 	w.Resize(fyne.NewSize(860, 620))
 
-	cfg := loadConfig()
+	cfg, legacyKey, cfgErr := loadConfig()
+	if cfgErr != nil {
+		cfg = config.Defaults()
+	}
+	if cfg.APIKey == "" {
+		cfg.APIKey = legacyKey
+	}
 
 	baseURLEntry := widget.NewEntry()
 	baseURLEntry.SetText(cfg.BaseURL)
 	apiKeyEntry := widget.NewPasswordEntry()
 	apiKeyEntry.SetText(cfg.APIKey)
+	apiKeyEntry.SetPlaceHolder("not saved; use a key file or key command")
+	keyFileEntry := widget.NewEntry()
+	keyFileEntry.SetText(cfg.KeyFile)
+	keyFileEntry.SetPlaceHolder("file holding the API key")
+	keyCommandEntry := widget.NewEntry()
+	keyCommandEntry.SetText(cfg.KeyCommand)
+	keyCommandEntry.SetPlaceHolder("command printing the API key, e.g. op read op://vault/immich/key")
+	emailEntry := widget.NewEntry()
+	emailEntry.SetText(cfg.Email)
+	emailEntry.SetPlaceHolder("only without an API key")
+	passwordEntry := widget.NewPasswordEntry()
+	passwordEntry.SetText(cfg.Password)
+	sharedLinkEntry := widget.NewEntry()
+	sharedLinkEntry.SetText(cfg.SharedLink)
+	sharedLinkEntry.SetPlaceHolder("https://host/share/... (uploads into that album)")
 	rootEntry := widget.NewEntry()
 	rootEntry.SetText(cfg.Root)
 
@@ -109,6 +121,29 @@ func main() {
 	ignoreEntry := widget.NewEntry()
 	ignoreEntry.SetText(cfg.IgnoreDir)
 
+	// fieldValues reads the settings in the window as text, to tell the
+	// ones the user changed from the loaded ones.
+	fieldValues := func() map[string]string {
+		return map[string]string{
+			"key":            apiKeyEntry.Text,
+			"immich":         baseURLEntry.Text,
+			"key-file":       keyFileEntry.Text,
+			"key-command":    keyCommandEntry.Text,
+			"email":          emailEntry.Text,
+			"shared-link":    sharedLinkEntry.Text,
+			"root":           rootEntry.Text,
+			"deep":           strconv.FormatBool(deepCheck.Checked),
+			"checksum":       strconv.FormatBool(checksumCheck.Checked),
+			"smallest-first": strconv.FormatBool(smallestFirstCheck.Checked),
+			"dedupe-add":     strconv.FormatBool(dedupeAddCheck.Checked),
+			"workers":        workersEntry.Text,
+			"batch":          batchEntry.Text,
+			"timeout":        timeoutEntry.Text,
+			"ignore-dir":     ignoreEntry.Text,
+		}
+	}
+	saved := fieldValues()
+
 	logBox := widget.NewMultiLineEntry()
 	logBox.Wrapping = fyne.TextWrapBreak
 	logBox.Disable()
@@ -121,6 +156,12 @@ func main() {
 		logBox.Disable()
 		scroll.ScrollToBottom()
 	}
+	if cfgErr != nil {
+		appendLog(fmt.Sprintf("Config: %v (using defaults)\n", cfgErr))
+	}
+	if legacyKey != "" {
+		appendLog("Config: settings imported from config.json, which is removed. The API key is no longer saved: set a key file or key command to keep it.\n")
+	}
 
 	pickBtn := widget.NewButton("Choose root folder...", func() {
 		d := dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
@@ -151,7 +192,12 @@ func main() {
 
 		// collect config
 		cfg.BaseURL = baseURLEntry.Text
-		cfg.APIKey = apiKeyEntry.Text
+		cfg.APIKey = apiKeyEntry.Text // not saved
+		cfg.KeyFile = keyFileEntry.Text
+		cfg.KeyCommand = keyCommandEntry.Text
+		cfg.Email = emailEntry.Text
+		cfg.Password = passwordEntry.Text // not saved
+		cfg.SharedLink = sharedLinkEntry.Text
 		cfg.Root = rootEntry.Text
 		cfg.Deep = deepCheck.Checked
 		cfg.Checksum = checksumCheck.Checked
@@ -165,7 +211,25 @@ func main() {
 			cfg.Timeout = d
 		}
 
-		_ = saveConfig(cfg)
+		values := fieldValues()
+		var changed []string
+		for _, k := range guiKeys {
+			if values[k] != saved[k] {
+				changed = append(changed, k)
+			}
+		}
+		if values["key"] != saved["key"] && cfg.APIKey == "" {
+			// a key saved by older versions: clearing it drops it from the
+			// file too, wherever it was set
+			_ = config.Remove("", cfg.Profile, "key")
+			if cfg.Profile != "" {
+				_ = config.Remove("", "", "key")
+			}
+		}
+		if len(changed) > 0 {
+			_ = config.Save("", cfg.Profile, cfg, changed...)
+		}
+		saved = values
 
 		logBox.Enable()
 		logBox.SetText("")
@@ -181,24 +245,21 @@ func main() {
 				runningMu.Unlock()
 			}()
 
-			opt := uploader.Options{
-				BaseURL:       cfg.BaseURL,
-				APIKey:        cfg.APIKey,
-				Root:          cfg.Root,
-				Deep:          cfg.Deep,
-				Checksum:      cfg.Checksum,
-				BatchSize:     cfg.BatchSize,
-				Workers:       cfg.Workers,
-				SmallestFirst: cfg.SmallestFirst,
-				IgnoreDir:     cfg.IgnoreDir,
-				Timeout:       cfg.Timeout,
-				DedupeAdd:     cfg.DedupeAdd,
+			runCfg := cfg
+			err := runCfg.ResolveAPIKey(context.Background())
+			if err == nil && runCfg.APIKey == "" {
+				err = runCfg.ResolvePassword()
+			}
+			var opt uploader.Options
+			if err == nil {
+				opt, err = runCfg.Options()
+			}
+			if err == nil {
+				err = uploader.Run(context.Background(), opt, func(format string, args ...any) {
+					msg := fmt.Sprintf(format, args...)
+					fyne.Do(func() { appendLog(msg) })
+				})
 			}
-
-			err := uploader.Run(context.Background(), opt, func(format string, args ...any) {
-				msg := fmt.Sprintf(format, args...)
-				fyne.Do(func() { appendLog(msg) })
-			})
 
 			fyne.Do(func() {
 				if err != nil {
@@ -213,6 +274,11 @@ func main() {
 	form := widget.NewForm(
 		widget.NewFormItem("Immich URL", baseURLEntry),
 		widget.NewFormItem("API Key", apiKeyEntry),
+		widget.NewFormItem("Key file", keyFileEntry),
+		widget.NewFormItem("Key command", keyCommandEntry),
+		widget.NewFormItem("Email", emailEntry),
+		widget.NewFormItem("Password", passwordEntry),
+		widget.NewFormItem("Shared link", sharedLinkEntry),
 		widget.NewFormItem("Root Folder", container.NewBorder(nil, nil, nil, pickBtn, rootEntry)),
 		widget.NewFormItem("Workers", workersEntry),
 		widget.NewFormItem("Batch", batchEntry),
@@ -0,0 +1,142 @@
+package immich
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Albums:
+// - GET    /albums             (AlbumResponseDto[])
+// - POST   /albums             (CreateAlbumDto -> AlbumResponseDto)
+// - GET    /albums/{id}        (AlbumResponseDto)
+// - PATCH  /albums/{id}        (UpdateAlbumDto -> AlbumResponseDto)
+// - DELETE /albums/{id}
+// - PUT    /albums/{id}/assets (BulkIdsDto -> BulkIdResponseDto[])
+// - DELETE /albums/{id}/assets (BulkIdsDto -> BulkIdResponseDto[])
+
+// Album is AlbumResponseDto.
+type Album struct {
+	ID                    string    `json:"id"`
+	AlbumName             string    `json:"albumName"`
+	Description           string    `json:"description"`
+	AlbumThumbnailAssetID *string   `json:"albumThumbnailAssetId"`
+	OwnerID               string    `json:"ownerId"`
+	Owner                 User      `json:"owner"`
+	AssetCount            int       `json:"assetCount"`
+	Assets                []Asset   `json:"assets"`
+	Shared                bool      `json:"shared"`
+	HasSharedLink         bool      `json:"hasSharedLink"`
+	IsActivityEnabled     bool      `json:"isActivityEnabled"`
+	Order                 string    `json:"order,omitempty"` // asc or desc
+	CreatedAt             time.Time `json:"createdAt"`
+	UpdatedAt             time.Time `json:"updatedAt"`
+}
+
+// CreateAlbum is CreateAlbumDto.
+type CreateAlbum struct {
+	AlbumName   string   `json:"albumName"`
+	Description string   `json:"description,omitempty"`
+	AssetIDs    []string `json:"assetIds,omitempty"`
+}
+
+// UpdateAlbum is UpdateAlbumDto; nil fields are left unchanged.
+type UpdateAlbum struct {
+	AlbumName             *string `json:"albumName,omitempty"`
+	Description           *string `json:"description,omitempty"`
+	AlbumThumbnailAssetID *string `json:"albumThumbnailAssetId,omitempty"`
+	IsActivityEnabled     *bool   `json:"isActivityEnabled,omitempty"`
+	Order                 *string `json:"order,omitempty"`
+}
+
+// BulkIDResult is BulkIdResponseDto: the outcome for one id of a bulk call.
+type BulkIDResult struct {
+	ID      string `json:"id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"` // duplicate, no_permission, not_found, unknown
+}
+
+type bulkIDs struct {
+	IDs []string `json:"ids"`
+}
+
+// ListAlbums returns the user's albums. shared filters to shared (true) or
+// not shared (false) albums; nil returns both.
+func (c *Client) ListAlbums(ctx context.Context, shared *bool) ([]Album, error) {
+	path, err := c.path(c.api().Albums)
+	if err != nil {
+		return nil, err
+	}
+	var q url.Values
+	if shared != nil {
+		q = url.Values{"shared": {strconv.FormatBool(*shared)}}
+	}
+	var out []Album
+	err = c.do(ctx, call{method: http.MethodGet, path: path, query: q, out: &out})
+	return out, err
+}
+
+// GetAlbum returns an album, without its assets if withoutAssets is set.
+func (c *Client) GetAlbum(ctx context.Context, id string, withoutAssets bool) (Album, error) {
+	var out Album
+	path, err := c.path(c.api().Album, id)
+	if err != nil {
+		return out, err
+	}
+	var q url.Values
+	if withoutAssets {
+		q = url.Values{"withoutAssets": {"true"}}
+	}
+	err = c.do(ctx, call{method: http.MethodGet, path: path, query: q, out: &out})
+	return out, err
+}
+
+func (c *Client) CreateAlbum(ctx context.Context, in CreateAlbum) (Album, error) {
+	var out Album
+	path, err := c.path(c.api().Albums)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodPost, path: path, in: in, out: &out})
+	return out, err
+}
+
+func (c *Client) UpdateAlbum(ctx context.Context, id string, in UpdateAlbum) (Album, error) {
+	var out Album
+	path, err := c.path(c.api().Album, id)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodPatch, path: path, in: in, out: &out})
+	return out, err
+}
+
+func (c *Client) DeleteAlbum(ctx context.Context, id string) error {
+	path, err := c.path(c.api().Album, id)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, call{method: http.MethodDelete, path: path})
+}
+
+// AddAssetsToAlbum adds assets to an album. Assets already in it come back
+// with Error "duplicate".
+func (c *Client) AddAssetsToAlbum(ctx context.Context, albumID string, assetIDs []string) ([]BulkIDResult, error) {
+	return c.albumAssets(ctx, http.MethodPut, albumID, assetIDs)
+}
+
+func (c *Client) RemoveAssetsFromAlbum(ctx context.Context, albumID string, assetIDs []string) ([]BulkIDResult, error) {
+	return c.albumAssets(ctx, http.MethodDelete, albumID, assetIDs)
+}
+
+func (c *Client) albumAssets(ctx context.Context, method, albumID string, assetIDs []string) ([]BulkIDResult, error) {
+	path, err := c.path(c.api().AlbumAssets, albumID)
+	if err != nil {
+		return nil, err
+	}
+	var out []BulkIDResult
+	err = c.do(ctx, call{method: method, path: path, in: bulkIDs{IDs: assetIDs}, out: &out})
+	return out, err
+}
@@ -0,0 +1,165 @@
+package immich
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// ServerVersion is a server release (ServerVersionResponseDto).
+type ServerVersion struct {
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+	Patch int `json:"patch"`
+}
+
+func (v ServerVersion) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Less reports whether v is an older release than o.
+func (v ServerVersion) Less(o ServerVersion) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+// API is the endpoint set of one range of server releases [Min, Max). The
+// client only ever builds paths through it; %s stands for an id. An empty
+// path means the releases don't have the endpoint (ErrUnsupported).
+type API struct {
+	Name     string
+	Min, Max ServerVersion
+
+	Ping       string
+	Version    string
+	Storage    string
+	About      string
+	Features   string
+	MediaTypes string
+
+	UsersMe      string
+	APIKeysMe    string
+	SharedLinkMe string
+
+	Albums      string // GET list, POST create
+	Album       string // GET, PATCH, DELETE
+	AlbumAssets string // PUT add, DELETE remove
+
+	Upload          string // POST multipart
+	Assets          string // PUT bulk update, DELETE bulk delete
+	Asset           string // GET, PUT
+	BulkUploadCheck string
+
+	Stacks string // GET list, POST create, DELETE bulk
+	Stack  string // GET, PUT, DELETE
+
+	Tags          string // GET list, POST create, PUT upsert
+	Tag           string // GET, DELETE
+	TagAssets     string // PUT tag, DELETE untag
+	BulkTagAssets string // PUT
+
+	SearchMetadata string
+
+	// LegacyUploadStatus: the upload response reports duplicates as
+	// {"duplicate": true} instead of {"status": "duplicate"}.
+	LegacyUploadStatus bool
+}
+
+// APIs are ordered oldest first. Releases before v1.106 used singular
+// resource paths (/asset, /album, /server-info); v1.106 introduced the plural
+// paths that v2 stabilized.
+var APIs = []*API{
+	{
+		Name: "v1 legacy", Min: ServerVersion{1, 90, 0}, Max: ServerVersion{1, 106, 0},
+		Ping: "/server-info/ping", Version: "/server-info/version", Storage: "/server-info",
+		Features: "/server-info/features", MediaTypes: "/server-info/media-types",
+		UsersMe: "/user/me", SharedLinkMe: "/shared-link/me",
+		Albums: "/album", Album: "/album/%s", AlbumAssets: "/album/%s/assets",
+		Upload: "/asset/upload", Assets: "/asset", Asset: "/asset/%s", BulkUploadCheck: "/asset/bulk-upload-check",
+		SearchMetadata:     "/search/metadata",
+		LegacyUploadStatus: true,
+	},
+	plural("v1", ServerVersion{1, 106, 0}, ServerVersion{2, 0, 0}),
+	plural("v2", ServerVersion{2, 0, 0}, ServerVersion{3, 0, 0}),
+}
+
+// plural is the endpoint set of v1.106 and later.
+func plural(name string, min, max ServerVersion) *API {
+	return &API{
+		Name: name, Min: min, Max: max,
+		Ping: "/server/ping", Version: "/server/version", Storage: "/server/storage",
+		About: "/server/about", Features: "/server/features", MediaTypes: "/server/media-types",
+		UsersMe: "/users/me", APIKeysMe: "/api-keys/me", SharedLinkMe: "/shared-links/me",
+		Albums: "/albums", Album: "/albums/%s", AlbumAssets: "/albums/%s/assets",
+		Upload: "/assets", Assets: "/assets", Asset: "/assets/%s", BulkUploadCheck: "/assets/bulk-upload-check",
+		Stacks: "/stacks", Stack: "/stacks/%s",
+		Tags: "/tags", Tag: "/tags/%s", TagAssets: "/tags/%s/assets", BulkTagAssets: "/tags/assets",
+		SearchMetadata: "/search/metadata",
+	}
+}
+
+// Latest is assumed until the server version is known.
+var Latest = APIs[len(APIs)-1]
+
+// APIFor picks the endpoint set for v, or explains why none fits.
+func APIFor(v ServerVersion) (*API, error) {
+	oldest := APIs[0]
+	if v.Less(oldest.Min) {
+		return nil, fmt.Errorf("server %s is older than the oldest supported release %s; upgrade Immich", v, oldest.Min)
+	}
+	for _, a := range APIs {
+		if !v.Less(a.Min) && v.Less(a.Max) {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("server %s is newer than the newest known API (%s, before %s); upgrade immich-uploader", v, Latest.Name, Latest.Max)
+}
+
+// PingPaths are tried in order to find out which generation the server is.
+func PingPaths() []string {
+	var paths []string
+	for i := len(APIs) - 1; i >= 0; i-- {
+		p := APIs[i].Ping
+		if !slices.Contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// VersionPath returns the version endpoint served next to ping.
+func VersionPath(ping string) string {
+	for _, a := range APIs {
+		if a.Ping == ping {
+			return a.Version
+		}
+	}
+	return Latest.Version
+}
+
+// UseVersion asks the server for its release and switches to its endpoint
+// set.
+func (c *Client) UseVersion(ctx context.Context) (ServerVersion, error) {
+	var v ServerVersion
+	var err error
+	for _, ping := range PingPaths() {
+		if err = c.do(ctx, call{method: http.MethodGet, path: VersionPath(ping), out: &v}); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return v, err
+	}
+	api, err := APIFor(v)
+	if err != nil {
+		return v, err
+	}
+	c.API = api
+	return v, nil
+}
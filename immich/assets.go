@@ -0,0 +1,251 @@
+package immich
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+// Assets:
+// - POST   /assets                   (multipart AssetMediaCreateDto -> AssetMediaResponseDto)
+// - POST   /assets/bulk-upload-check (AssetBulkUploadCheckDto -> AssetBulkUploadCheckResponseDto)
+// - GET    /assets/{id}              (AssetResponseDto)
+// - PUT    /assets/{id}              (UpdateAssetDto -> AssetResponseDto)
+// - PUT    /assets                   (AssetBulkUpdateDto)
+// - DELETE /assets                   (AssetBulkDeleteDto)
+
+// Asset is AssetResponseDto.
+type Asset struct {
+	ID               string    `json:"id"`
+	Type             string    `json:"type"` // IMAGE, VIDEO, AUDIO, OTHER
+	OriginalFileName string    `json:"originalFileName"`
+	OriginalPath     string    `json:"originalPath"`
+	OriginalMimeType string    `json:"originalMimeType,omitempty"`
+	Checksum         string    `json:"checksum"` // base64 sha1
+	DeviceAssetID    string    `json:"deviceAssetId"`
+	DeviceID         string    `json:"deviceId"`
+	OwnerID          string    `json:"ownerId"`
+	FileCreatedAt    time.Time `json:"fileCreatedAt"`
+	FileModifiedAt   time.Time `json:"fileModifiedAt"`
+	LocalDateTime    time.Time `json:"localDateTime"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+	Duration         string    `json:"duration"`
+	IsFavorite       bool      `json:"isFavorite"`
+	IsArchived       bool      `json:"isArchived"`
+	IsTrashed        bool      `json:"isTrashed"`
+	IsOffline        bool      `json:"isOffline"`
+	Visibility       string    `json:"visibility"` // timeline, archive, hidden, locked
+	LivePhotoVideoID *string   `json:"livePhotoVideoId"`
+	Tags             []Tag     `json:"tags,omitempty"`
+	Stack            *struct {
+		ID             string `json:"id"`
+		PrimaryAssetID string `json:"primaryAssetId"`
+		AssetCount     int    `json:"assetCount"`
+	} `json:"stack,omitempty"`
+}
+
+// Upload statuses (AssetMediaStatus).
+const (
+	StatusCreated   = "created"
+	StatusReplaced  = "replaced"
+	StatusDuplicate = "duplicate"
+)
+
+// AssetUpload is one file to upload (AssetMediaCreateDto).
+type AssetUpload struct {
+	DeviceAssetID  string
+	DeviceID       string
+	FileCreatedAt  time.Time
+	FileModifiedAt time.Time
+	Filename       string
+	// Checksum is the hex sha1 of the file, sent as x-immich-checksum so the
+	// server can report a duplicate without storing the upload.
+	Checksum string
+	// Open returns the file contents. It is called again for each retry.
+	Open func() (io.ReadCloser, error)
+}
+
+// UploadResult is AssetMediaResponseDto.
+type UploadResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status"` // StatusCreated, StatusReplaced or StatusDuplicate
+}
+
+// UploadAsset streams a file to the server. Errors opening or reading the
+// file are returned as they are, not as network errors.
+func (c *Client) UploadAsset(ctx context.Context, u AssetUpload) (UploadResult, error) {
+	var out UploadResult
+	path, err := c.path(c.api().Upload)
+	if err != nil {
+		return out, err
+	}
+	newReq := func() (*http.Request, error) {
+		f, err := u.Open()
+		if err != nil {
+			return nil, err
+		}
+		// Stream the multipart body through a pipe instead of buffering the file.
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		go func() {
+			defer f.Close()
+			fields := [][2]string{
+				{"deviceId", u.DeviceID},
+				{"deviceAssetId", u.DeviceAssetID},
+				{"fileCreatedAt", u.FileCreatedAt.UTC().Format(time.RFC3339Nano)},
+				{"fileModifiedAt", u.FileModifiedAt.UTC().Format(time.RFC3339Nano)},
+				{"filename", u.Filename},
+			}
+			for _, kv := range fields {
+				if err := mw.WriteField(kv[0], kv[1]); err != nil {
+					_ = pw.CloseWithError(err)
+					return
+				}
+			}
+			part, err := mw.CreateFormFile("assetData", u.Filename)
+			if err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(part, f); err != nil {
+				if !errors.Is(err, io.ErrClosedPipe) { // the request gave up first
+					err = &bodyError{err: err}
+				}
+				_ = pw.CloseWithError(err)
+				return
+			}
+			_ = pw.CloseWithError(mw.Close())
+		}()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, pr)
+		if err != nil {
+			_ = pr.Close()
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		if u.Checksum != "" {
+			req.Header.Set("x-immich-checksum", u.Checksum)
+		}
+		return req, nil
+	}
+	b, err := c.exchange(ctx, exchange{op: "upload", upload: true}, newReq)
+	if err != nil {
+		return out, err
+	}
+	var legacy struct {
+		UploadResult
+		Duplicate bool `json:"duplicate"`
+	}
+	if err := jsonDecode(b, &legacy); err != nil {
+		return out, c.scrub(err)
+	}
+	out = legacy.UploadResult
+	if c.api().LegacyUploadStatus && out.Status == "" {
+		out.Status = StatusCreated
+		if legacy.Duplicate {
+			out.Status = StatusDuplicate
+		}
+	}
+	return out, nil
+}
+
+// UploadCheck is one file of a bulk upload check (AssetBulkUploadCheckItem).
+// ID is the caller's, echoed in the result.
+type UploadCheck struct {
+	ID       string `json:"id"`
+	Checksum string `json:"checksum"` // hex or base64 sha1
+}
+
+// UploadCheckResult is AssetBulkUploadCheckResult.
+type UploadCheckResult struct {
+	ID        string `json:"id"`
+	Action    string `json:"action"`           // accept or reject
+	Reason    string `json:"reason,omitempty"` // duplicate, unsupported-format
+	AssetID   string `json:"assetId,omitempty"`
+	IsTrashed bool   `json:"isTrashed,omitempty"`
+}
+
+// BulkUploadCheck asks which files the server already has, by checksum.
+func (c *Client) BulkUploadCheck(ctx context.Context, items []UploadCheck) ([]UploadCheckResult, error) {
+	path, err := c.path(c.api().BulkUploadCheck)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Results []UploadCheckResult `json:"results"`
+	}
+	in := struct {
+		Assets []UploadCheck `json:"assets"`
+	}{items}
+	err = c.do(ctx, call{method: http.MethodPost, path: path, in: in, out: &out})
+	return out.Results, err
+}
+
+func (c *Client) GetAsset(ctx context.Context, id string) (Asset, error) {
+	var out Asset
+	path, err := c.path(c.api().Asset, id)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodGet, path: path, out: &out})
+	return out, err
+}
+
+// UpdateAsset is UpdateAssetDto; nil fields are left unchanged.
+type UpdateAsset struct {
+	Description      *string  `json:"description,omitempty"`
+	DateTimeOriginal *string  `json:"dateTimeOriginal,omitempty"`
+	IsFavorite       *bool    `json:"isFavorite,omitempty"`
+	Visibility       *string  `json:"visibility,omitempty"`
+	Rating           *int     `json:"rating,omitempty"`
+	Latitude         *float64 `json:"latitude,omitempty"`
+	Longitude        *float64 `json:"longitude,omitempty"`
+	LivePhotoVideoID *string  `json:"livePhotoVideoId,omitempty"`
+}
+
+func (c *Client) UpdateAsset(ctx context.Context, id string, in UpdateAsset) (Asset, error) {
+	var out Asset
+	path, err := c.path(c.api().Asset, id)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodPut, path: path, in: in, out: &out})
+	return out, err
+}
+
+// UpdateAssets is AssetBulkUpdateDto: the same change for several assets.
+type UpdateAssets struct {
+	IDs              []string `json:"ids"`
+	Description      *string  `json:"description,omitempty"`
+	DateTimeOriginal *string  `json:"dateTimeOriginal,omitempty"`
+	IsFavorite       *bool    `json:"isFavorite,omitempty"`
+	Visibility       *string  `json:"visibility,omitempty"`
+	Rating           *int     `json:"rating,omitempty"`
+	Latitude         *float64 `json:"latitude,omitempty"`
+	Longitude        *float64 `json:"longitude,omitempty"`
+}
+
+func (c *Client) UpdateAssets(ctx context.Context, in UpdateAssets) error {
+	path, err := c.path(c.api().Assets)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, call{method: http.MethodPut, path: path, in: in})
+}
+
+// DeleteAssets moves assets to the trash, or deletes them for good if force
+// is set.
+func (c *Client) DeleteAssets(ctx context.Context, ids []string, force bool) error {
+	path, err := c.path(c.api().Assets)
+	if err != nil {
+		return err
+	}
+	in := struct {
+		IDs   []string `json:"ids"`
+		Force bool     `json:"force,omitempty"`
+	}{ids, force}
+	return c.do(ctx, call{method: http.MethodDelete, path: path, in: in})
+}
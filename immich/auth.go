@@ -0,0 +1,271 @@
+package immich
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Auth:
+// - API key:          x-api-key: <key>
+// - password session: POST /auth/login (LoginCredentialDto -> LoginResponseDto.accessToken),
+//                     then "Authorization: Bearer <token>"; POST /auth/validateToken, POST /auth/logout
+// - shared link:      ?key=<key> or ?slug=<slug>; GET /shared-links/me (SharedLinkResponseDto)
+
+// Auth sets the credentials on a request.
+type Auth interface {
+	Authorize(req *http.Request)
+	// Secrets are the credentials that must never appear in output.
+	Secrets() []string
+}
+
+// Redacted replaces the secrets hidden by Redact.
+const Redacted = "[REDACTED]"
+
+// Redact replaces every occurrence of the non-empty secrets in s with
+// Redacted. Longer secrets are replaced first, so a short one that happens to
+// occur inside another can't leave the rest of it visible.
+func Redact(s string, secrets ...string) string {
+	for _, secret := range bySize(secrets) {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	return s
+}
+
+// ContainsSecret reports whether s contains any of the non-empty secrets.
+func ContainsSecret(s string, secrets ...string) bool {
+	return slices.ContainsFunc(secrets, func(secret string) bool {
+		return secret != "" && strings.Contains(s, secret)
+	})
+}
+
+// RedactError hides the secrets in err's message, keeping err available to
+// errors.Is/As. It returns err itself if the message contains none of them.
+func RedactError(err error, secrets ...string) error {
+	if err == nil || !ContainsSecret(err.Error(), secrets...) {
+		return err
+	}
+	return &redactedError{err: err, secrets: secrets}
+}
+
+type redactedError struct {
+	err     error
+	secrets []string
+}
+
+func (e *redactedError) Error() string { return Redact(e.err.Error(), e.secrets...) }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// bySize returns the non-empty secrets, longest first.
+func bySize(secrets []string) []string {
+	out := slices.DeleteFunc(slices.Clone(secrets), func(s string) bool { return s == "" })
+	slices.SortFunc(out, func(a, b string) int { return len(b) - len(a) })
+	return out
+}
+
+// APIKey authenticates with an API key.
+type APIKey string
+
+func (k APIKey) Authorize(req *http.Request) { req.Header.Set("x-api-key", string(k)) }
+func (k APIKey) Secrets() []string           { return []string{string(k)} }
+
+// Session is a password login, started by Client.Login. When the server
+// rejects the access token, the client logs in again once and retries.
+type Session struct {
+	Email    string
+	Password string
+
+	loginMu sync.Mutex // serializes re-logins
+	mu      sync.Mutex
+	token   string
+}
+
+// Token returns the current access token ("" before the login).
+func (s *Session) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+func (s *Session) Authorize(req *http.Request) { req.Header.Set("Authorization", "Bearer "+s.Token()) }
+func (s *Session) Secrets() []string           { return []string{s.Password, s.Token()} }
+
+// SharedLink identifies a shared link by key or slug. Password is only
+// needed for password-protected links, which return a token that is then
+// sent as the immich_shared_link_token cookie (see Client.OpenSharedLink).
+type SharedLink struct {
+	Key      string
+	Slug     string
+	Password string
+
+	mu    sync.Mutex
+	token string
+}
+
+// ParseSharedLink accepts a bare key or a link as copied from Immich:
+// https://host/share/<key> or https://host/s/<slug>.
+func ParseSharedLink(s, password string) (*SharedLink, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		return &SharedLink{Key: s, Password: password}, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("shared link: %w", err)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) >= 2 {
+		switch v := parts[len(parts)-1]; parts[len(parts)-2] {
+		case "share":
+			return &SharedLink{Key: v, Password: password}, nil
+		case "s":
+			return &SharedLink{Slug: v, Password: password}, nil
+		}
+	}
+	return nil, fmt.Errorf("shared link %q: expected .../share/<key> or .../s/<slug>", s)
+}
+
+func (l *SharedLink) Authorize(req *http.Request) {
+	q := req.URL.Query()
+	if l.Key != "" {
+		q.Set("key", l.Key)
+	} else {
+		q.Set("slug", l.Slug)
+	}
+	req.URL.RawQuery = q.Encode()
+	if token := l.sessionToken(); token != "" {
+		req.AddCookie(&http.Cookie{Name: "immich_shared_link_token", Value: token})
+	}
+}
+
+func (l *SharedLink) Secrets() []string {
+	return []string{l.Key, l.Password, l.sessionToken()}
+}
+
+func (l *SharedLink) sessionToken() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.token
+}
+
+// LoginResponse is LoginResponseDto.
+type LoginResponse struct {
+	AccessToken          string `json:"accessToken"`
+	UserID               string `json:"userId"`
+	UserEmail            string `json:"userEmail"`
+	Name                 string `json:"name"`
+	IsAdmin              bool   `json:"isAdmin"`
+	ShouldChangePassword bool   `json:"shouldChangePassword"`
+}
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Login starts the password session of c.Auth, which must be a *Session, and
+// validates the new token.
+func (c *Client) Login(ctx context.Context) (LoginResponse, error) {
+	var out LoginResponse
+	s, ok := c.Auth.(*Session)
+	if !ok {
+		return out, errors.New("login: the client has no password session")
+	}
+	err := c.do(ctx, call{method: http.MethodPost, path: "/auth/login", in: loginRequest{Email: s.Email, Password: s.Password}, out: &out,
+		anonymous: true, noRenew: true})
+	if err != nil {
+		return out, fmt.Errorf("login as %s: %w", s.Email, err)
+	}
+	if out.AccessToken == "" {
+		return out, errors.New("login: no access token in response")
+	}
+	s.mu.Lock()
+	s.token = out.AccessToken
+	s.mu.Unlock()
+
+	var v struct {
+		AuthStatus bool `json:"authStatus"`
+	}
+	if err := c.do(ctx, call{method: http.MethodPost, path: "/auth/validateToken", out: &v, noRenew: true}); err != nil {
+		return out, fmt.Errorf("validate token: %w", err)
+	}
+	if !v.AuthStatus {
+		return out, errors.New("validate token: server rejected the new access token")
+	}
+	return out, nil
+}
+
+// Logout ends the password session.
+func (c *Client) Logout(ctx context.Context) error {
+	return c.do(ctx, call{method: http.MethodPost, path: "/auth/logout", noRenew: true})
+}
+
+// sessionToken returns the current session token ("" unless the client has
+// a password session).
+func (c *Client) sessionToken() string {
+	if s, ok := c.Auth.(*Session); ok {
+		return s.Token()
+	}
+	return ""
+}
+
+// renew logs in again after a 401 in password mode and reports whether the
+// request should be retried. token is the one the failed request used; if
+// another request already renewed it, the request is just retried.
+func (c *Client) renew(ctx context.Context, token string) bool {
+	s, ok := c.Auth.(*Session)
+	if !ok {
+		return false
+	}
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+	if s.Token() != token {
+		return true
+	}
+	_, err := c.Login(ctx)
+	return err == nil
+}
+
+// SharedLinkInfo is SharedLinkResponseDto.
+type SharedLinkInfo struct {
+	ID          string     `json:"id"`
+	Type        string     `json:"type"` // ALBUM or INDIVIDUAL
+	AllowUpload bool       `json:"allowUpload"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	Album       *Album     `json:"album"`
+	Token       *string    `json:"token"`
+}
+
+// OpenSharedLink reads the shared link of c.Auth, which must be a
+// *SharedLink. For password-protected links it keeps the returned token for
+// the following requests.
+func (c *Client) OpenSharedLink(ctx context.Context) (SharedLinkInfo, error) {
+	var out SharedLinkInfo
+	l, ok := c.Auth.(*SharedLink)
+	if !ok {
+		return out, errors.New("shared link: the client is not in shared link mode")
+	}
+	path, err := c.path(c.api().SharedLinkMe)
+	if err != nil {
+		return out, err
+	}
+	var q url.Values
+	if l.Password != "" {
+		q = url.Values{"password": {l.Password}}
+	}
+	if err := c.do(ctx, call{method: http.MethodGet, path: path, query: q, out: &out}); err != nil {
+		return out, err
+	}
+	if out.Token != nil && *out.Token != "" {
+		l.mu.Lock()
+		l.token = *out.Token
+		l.mu.Unlock()
+	}
+	return out, nil
+}
@@ -0,0 +1,318 @@
+// Package immich is a typed client for the Immich server API, written against
+// the OpenAPI spec bundled in internal/openapi. It covers albums, assets,
+// stacks, tags, search, server and users; the endpoints of older server
+// releases go through an API adapter (see api.go).
+//
+// A Client is safe for concurrent use once it is set up. It authenticates
+// every request (API key, password session or shared link, see auth.go),
+// retries requests the server asks to slow down for, and returns errors that
+// match ErrAuth, ErrNotFound, ErrServer, ... with errors.Is.
+package immich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls one Immich server.
+type Client struct {
+	// BaseURL is the API root, e.g. http://host:2283/api.
+	BaseURL string
+	Auth    Auth
+	// HTTP makes API calls (nil = http.DefaultClient). UploadHTTP makes
+	// uploads (nil = HTTP); it usually has no overall timeout, since an
+	// upload can take hours.
+	HTTP       *http.Client
+	UploadHTTP *http.Client
+	// API is the endpoint set of the server's release (nil = Latest). See
+	// UseVersion.
+	API   *API
+	Retry Retry
+}
+
+// New returns a client for the API at baseURL.
+func New(baseURL string, auth Auth) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Auth: auth}
+}
+
+// Retry is a client's retry policy. A request is retried when the server
+// rate-limits it (429, honoring Retry-After) and, if it is idempotent, when
+// the server is unavailable (502, 503, 504) or unreachable.
+type Retry struct {
+	Attempts   int           // retries after the first attempt (0 = none)
+	Backoff    time.Duration // first delay, doubled for each retry (0 = 1s)
+	MaxBackoff time.Duration // 0 = 30s
+}
+
+// API errors by kind. Errors returned by the client match one of these with
+// errors.Is, so callers can react to a class of failure instead of parsing
+// status codes.
+var (
+	ErrAuth        = errors.New("authentication failed") // 401: key revoked, session expired
+	ErrPermission  = errors.New("permission denied")     // 403: key lacks a permission
+	ErrNotFound    = errors.New("not found")             // 404: album or asset gone
+	ErrServer      = errors.New("server error")          // 5xx
+	ErrNetwork     = errors.New("server unreachable")    // connection refused, reset, timeout
+	ErrUnsupported = errors.New("not supported by this server release")
+)
+
+// StatusError is a non-2xx API response.
+type StatusError struct {
+	Op   string // "GET /albums", "upload"
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s failed: status=%d body=%s", e.Op, e.Code, e.Body)
+}
+
+// Is maps the status code to its error kind.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrAuth:
+		return e.Code == http.StatusUnauthorized
+	case ErrPermission:
+		return e.Code == http.StatusForbidden
+	case ErrNotFound:
+		return e.Code == http.StatusNotFound
+	case ErrServer:
+		return e.Code >= 500
+	}
+	return false
+}
+
+// NetworkError is a request that got no response at all.
+type NetworkError struct {
+	Op  string
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Op, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error { return e.Err }
+
+func (e *NetworkError) Is(target error) bool { return target == ErrNetwork }
+
+// bodyError is a failure to produce a request body (reading the file being
+// uploaded): a local error, not a network one.
+type bodyError struct{ err error }
+
+func (e *bodyError) Error() string { return e.err.Error() }
+func (e *bodyError) Unwrap() error { return e.err }
+
+func (c *Client) api() *API {
+	if c.API != nil {
+		return c.API
+	}
+	return Latest
+}
+
+func (c *Client) httpClient(upload bool) *http.Client {
+	switch {
+	case upload && c.UploadHTTP != nil:
+		return c.UploadHTTP
+	case c.HTTP != nil:
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
+
+// path formats an endpoint of the adapter, or fails if the server release
+// doesn't have it.
+func (c *Client) path(tmpl string, args ...any) (string, error) {
+	if tmpl == "" {
+		return "", fmt.Errorf("%w (%s API)", ErrUnsupported, c.api().Name)
+	}
+	for i, a := range args {
+		if s, ok := a.(string); ok {
+			args[i] = url.PathEscape(s)
+		}
+	}
+	return fmt.Sprintf(tmpl, args...), nil
+}
+
+// call is one JSON API request.
+type call struct {
+	method string
+	path   string
+	query  url.Values
+	in     any // request body
+	out    any // decoded 2xx response body
+
+	anonymous bool // send no credentials (login)
+	noRenew   bool // don't log in again on 401 (the login itself)
+}
+
+// do performs a JSON API call.
+func (c *Client) do(ctx context.Context, cl call) error {
+	var body []byte
+	if cl.in != nil {
+		b, err := json.Marshal(cl.in)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+	newReq := func() (*http.Request, error) {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, cl.method, c.BaseURL+cl.path, r)
+		if err != nil {
+			return nil, err
+		}
+		if len(cl.query) > 0 {
+			req.URL.RawQuery = cl.query.Encode()
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, nil
+	}
+	x := exchange{op: cl.method + " " + cl.path, idempotent: cl.method != http.MethodPost, anonymous: cl.anonymous, noRenew: cl.noRenew}
+	b, err := c.exchange(ctx, x, newReq)
+	if err != nil {
+		return err
+	}
+	if cl.out != nil && len(bytes.TrimSpace(b)) > 0 {
+		return c.scrub(jsonDecode(b, cl.out))
+	}
+	return nil
+}
+
+func jsonDecode(b []byte, out any) error {
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("decode response: %w (body=%s)", err, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// exchange describes how a request is sent.
+type exchange struct {
+	op         string
+	idempotent bool
+	upload     bool
+	anonymous  bool
+	noRenew    bool
+}
+
+// exchange sends the requests built by newReq until one succeeds or the
+// failure is final, and returns the response body. Credentials are set on
+// every attempt; a password session the server rejects is renewed once.
+// Errors never contain the credentials, even if the server echoes them back.
+func (c *Client) exchange(ctx context.Context, x exchange, newReq func() (*http.Request, error)) ([]byte, error) {
+	renewed := false
+	for attempt := 0; ; attempt++ {
+		token := c.sessionToken()
+		req, err := newReq()
+		if err != nil {
+			return nil, c.scrub(err)
+		}
+		req.Header.Set("Accept", "application/json")
+		if c.Auth != nil && !x.anonymous {
+			c.Auth.Authorize(req)
+		}
+
+		resp, err := c.httpClient(x.upload).Do(req)
+		if err != nil {
+			var be *bodyError
+			switch {
+			case errors.As(err, &be):
+				return nil, c.scrub(be.err)
+			case ctx.Err() != nil:
+				return nil, c.scrub(err)
+			}
+			if x.idempotent && c.backoff(ctx, attempt, 0) {
+				continue
+			}
+			return nil, c.scrub(&NetworkError{Op: x.op, Err: err})
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return b, nil
+		}
+
+		se := &StatusError{Op: x.op, Code: resp.StatusCode, Body: strings.TrimSpace(string(b))}
+		switch se.Code {
+		case http.StatusUnauthorized:
+			if !x.noRenew && !renewed && c.renew(ctx, token) {
+				renewed = true
+				continue
+			}
+		case http.StatusTooManyRequests:
+			if c.backoff(ctx, attempt, retryAfter(resp.Header.Get("Retry-After"))) {
+				continue
+			}
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			if x.idempotent && c.backoff(ctx, attempt, retryAfter(resp.Header.Get("Retry-After"))) {
+				continue
+			}
+		}
+		return nil, c.scrub(se)
+	}
+}
+
+// backoff waits before retry attempt+1 and reports whether to make it. wait,
+// if set, is the server's Retry-After.
+func (c *Client) backoff(ctx context.Context, attempt int, wait time.Duration) bool {
+	if attempt >= c.Retry.Attempts {
+		return false
+	}
+	d := c.Retry.Backoff
+	if d <= 0 {
+		d = time.Second
+	}
+	limit := c.Retry.MaxBackoff
+	if limit <= 0 {
+		limit = 30 * time.Second
+	}
+	d = min(d<<attempt, limit)
+	if wait > 0 {
+		d = min(wait, limit)
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// retryAfter parses a Retry-After header (seconds or an HTTP date).
+func retryAfter(h string) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(h); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// scrub hides the credentials in err's message, keeping err available to
+// errors.Is/As.
+func (c *Client) scrub(err error) error {
+	if err == nil || c.Auth == nil {
+		return err
+	}
+	return RedactError(err, c.Auth.Secrets()...)
+}
@@ -0,0 +1,152 @@
+package immichtest
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fault changes how the server answers matching requests.
+type Fault struct {
+	// Method and Path select the requests ("" or "*" = any). Path is under
+	// /api and may contain * for one path segment, e.g. /albums/*/assets.
+	Method string
+	Path   string
+	// Times is how many matching requests the fault applies to (0 = all).
+	Times int
+
+	// Latency delays the response.
+	Latency time.Duration
+	// Status, if set, is returned instead of handling the request, e.g. 503
+	// or 429 (with RetryAfter as the Retry-After header, if set).
+	Status     int
+	RetryAfter time.Duration
+	// Drop closes the connection without a response.
+	Drop bool
+}
+
+// Inject adds a fault. Faults apply in the order they were added; the
+// latencies of all matching faults add up, and the first matching Status or
+// Drop decides the response.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// ClearFaults removes all faults.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// inject applies the faults matching r and reports whether they answered it.
+func (s *Server) inject(w *recorder, r *http.Request, p string) bool {
+	var (
+		delay time.Duration
+		fail  *Fault
+	)
+	s.mu.Lock()
+	kept := s.faults[:0]
+	for _, f := range s.faults {
+		if !matches(f.Method, f.Path, r.Method, p) || (fail != nil && (f.Status != 0 || f.Drop)) {
+			kept = append(kept, f)
+			continue
+		}
+		delay += f.Latency
+		if f.Status != 0 || f.Drop {
+			fail = f
+		}
+		if f.Times > 0 {
+			if f.Times--; f.Times == 0 {
+				continue
+			}
+		}
+		kept = append(kept, f)
+	}
+	s.faults = kept
+	s.mu.Unlock()
+
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		select {
+		case <-r.Context().Done():
+			t.Stop()
+			return true
+		case <-t.C:
+		}
+	}
+	switch {
+	case fail == nil:
+		return false
+	case fail.Drop:
+		if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+			_ = conn.Close()
+			return true
+		}
+		panic(http.ErrAbortHandler) // HTTP/2: reset the stream instead
+	default:
+		if fail.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int((fail.RetryAfter+time.Second-1)/time.Second)))
+		}
+		writeError(w, fail.Status, "injected fault")
+		return true
+	}
+}
+
+// matches reports whether a request is selected by method and pattern.
+func matches(method, pattern, reqMethod, reqPath string) bool {
+	if method != "" && method != "*" && !strings.EqualFold(method, reqMethod) {
+		return false
+	}
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	ok, _ := path.Match(pattern, reqPath)
+	return ok
+}
+
+// ParseFault parses the command-line form of a fault:
+//
+//	[METHOD] PATH ACTION [xN]
+//
+// where ACTION is a status code (503, 429), "drop", or a latency such as
+// 200ms, and xN limits the fault to N requests. Examples: "POST /assets 503
+// x2", "* 300ms", "GET /albums drop x1".
+func ParseFault(s string) (Fault, error) {
+	fields := strings.Fields(s)
+	var f Fault
+	if n := len(fields); n > 0 && strings.HasPrefix(fields[n-1], "x") {
+		times, err := strconv.Atoi(fields[n-1][1:])
+		if err != nil || times < 1 {
+			return f, fmt.Errorf("fault %q: invalid count %q", s, fields[n-1])
+		}
+		f.Times, fields = times, fields[:n-1]
+	}
+	switch len(fields) {
+	case 2:
+		f.Path = fields[0]
+	case 3:
+		f.Method, f.Path = strings.ToUpper(fields[0]), fields[1]
+	default:
+		return f, fmt.Errorf("fault %q: want [METHOD] PATH ACTION [xN]", s)
+	}
+	action := fields[len(fields)-1]
+	if action == "drop" {
+		f.Drop = true
+	} else if code, err := strconv.Atoi(action); err == nil && code >= 400 && code <= 599 {
+		f.Status = code
+		if code == http.StatusTooManyRequests {
+			f.RetryAfter = time.Second
+		}
+	} else if d, err := time.ParseDuration(action); err == nil && d > 0 {
+		f.Latency = d
+	} else {
+		return f, fmt.Errorf("fault %q: action %q is not a status code, drop or a duration", s, action)
+	}
+	return f, nil
+}
@@ -0,0 +1,683 @@
+package immichtest
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+func (s *Server) routes() {
+	m := http.NewServeMux()
+	m.HandleFunc("GET /api/server/ping", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"res": "pong"})
+	})
+	m.HandleFunc("GET /api/server/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Version)
+	})
+	m.HandleFunc("GET /api/server/about", s.about)
+	m.HandleFunc("GET /api/server/features", s.features)
+	m.HandleFunc("GET /api/server/media-types", s.mediaTypes)
+	m.HandleFunc("GET /api/server/storage", s.storage)
+	m.HandleFunc("GET /api/users/me", s.me)
+	m.HandleFunc("GET /api/api-keys/me", s.apiKey)
+
+	m.HandleFunc("GET /api/albums", s.listAlbums)
+	m.HandleFunc("POST /api/albums", s.createAlbum)
+	m.HandleFunc("GET /api/albums/{id}", s.getAlbum)
+	m.HandleFunc("DELETE /api/albums/{id}", s.deleteAlbum)
+	m.HandleFunc("PUT /api/albums/{id}/assets", s.addAlbumAssets)
+	m.HandleFunc("DELETE /api/albums/{id}/assets", s.removeAlbumAssets)
+
+	m.HandleFunc("POST /api/assets", s.upload)
+	m.HandleFunc("POST /api/assets/bulk-upload-check", s.bulkUploadCheck)
+	m.HandleFunc("GET /api/assets/{id}", s.getAsset)
+	m.HandleFunc("DELETE /api/assets", s.deleteAssets)
+
+	m.HandleFunc("GET /api/stacks", s.listStacks)
+	m.HandleFunc("POST /api/stacks", s.createStack)
+	m.HandleFunc("DELETE /api/stacks", s.deleteStacks)
+	m.HandleFunc("GET /api/stacks/{id}", s.getStack)
+	m.HandleFunc("PUT /api/stacks/{id}", s.updateStack)
+	m.HandleFunc("DELETE /api/stacks/{id}", s.deleteStack)
+
+	m.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "Cannot "+r.Method+" "+r.URL.Path)
+	})
+	s.mux = m
+}
+
+// Server info
+
+func (s *Server) about(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"version":    s.Version.String(),
+		"versionUrl": "https://github.com/immich-app/immich/releases/tag/" + s.Version.String(),
+		"licensed":   false,
+	})
+}
+
+func (s *Server) features(w http.ResponseWriter, r *http.Request) {
+	f := map[string]any{}
+	for _, k := range []string{"configFile", "duplicateDetection", "email", "facialRecognition", "importFaces",
+		"map", "oauth", "oauthAutoLaunch", "ocr", "reverseGeocoding", "search", "sidecar", "smartSearch"} {
+		f[k] = false
+	}
+	f["passwordLogin"], f["trash"] = true, true
+	writeJSON(w, http.StatusOK, f)
+}
+
+func (s *Server) mediaTypes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"image":   []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".heic", ".heif", ".tif", ".tiff", ".bmp"},
+		"video":   []string{".mp4", ".mov", ".m4v", ".mkv", ".avi", ".webm"},
+		"sidecar": []string{".xmp"},
+	})
+}
+
+func (s *Server) storage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	size, used := s.DiskSize, s.used
+	s.mu.Unlock()
+	free := max(size-used, 0)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"diskSize":            fmt.Sprintf("%d B", size),
+		"diskSizeRaw":         size,
+		"diskUse":             fmt.Sprintf("%d B", used),
+		"diskUseRaw":          used,
+		"diskAvailable":       fmt.Sprintf("%d B", free),
+		"diskAvailableRaw":    free,
+		"diskUsagePercentage": float64(used) / float64(max(size, 1)) * 100,
+	})
+}
+
+// Users
+
+func (s *Server) me(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	used := s.used
+	s.mu.Unlock()
+	u := s.userJSON()
+	u["isAdmin"] = true
+	u["license"] = nil
+	u["oauthId"] = ""
+	u["quotaSizeInBytes"] = nil
+	u["quotaUsageInBytes"] = used
+	u["shouldChangePassword"] = false
+	u["status"] = "active"
+	u["storageLabel"] = "admin"
+	u["createdAt"] = s.start
+	u["updatedAt"] = s.start
+	u["deletedAt"] = nil
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (s *Server) apiKey(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":          s.user.ID,
+		"name":        "immichtest",
+		"permissions": []string{"all"},
+		"createdAt":   s.start,
+		"updatedAt":   s.start,
+	})
+}
+
+func (s *Server) userJSON() map[string]any {
+	return map[string]any{
+		"id":               s.user.ID,
+		"name":             s.user.Name,
+		"email":            s.user.Email,
+		"avatarColor":      "primary",
+		"profileImagePath": "",
+		"profileChangedAt": s.start,
+	}
+}
+
+// Albums
+
+func (s *Server) listAlbums(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []map[string]any{}
+	for _, a := range s.albums {
+		out = append(out, s.albumJSON(a, false))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) createAlbum(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		AlbumName   string   `json:"albumName"`
+		Description string   `json:"description"`
+		AssetIDs    []string `json:"assetIds"`
+	}
+	if !readJSON(w, r, &in) {
+		return
+	}
+	if in.AlbumName == "" {
+		writeError(w, http.StatusBadRequest, "albumName should not be empty")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	a := &album{id: newID(), name: in.AlbumName, description: in.Description, created: now, updated: now}
+	for _, id := range in.AssetIDs {
+		if s.byID[id] != nil && !slices.Contains(a.assets, id) {
+			a.assets = append(a.assets, id)
+		}
+	}
+	s.albums = append(s.albums, a)
+	writeJSON(w, http.StatusCreated, s.albumJSON(a, true))
+}
+
+func (s *Server) getAlbum(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.album(r.PathValue("id"))
+	if a == nil {
+		writeError(w, http.StatusBadRequest, "Not found or no album.read access")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.albumJSON(a, r.URL.Query().Get("withoutAssets") != "true"))
+}
+
+func (s *Server) deleteAlbum(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.albums, func(a *album) bool { return a.id == r.PathValue("id") })
+	if i < 0 {
+		writeError(w, http.StatusBadRequest, "Not found or no album.delete access")
+		return
+	}
+	s.albums = slices.Delete(s.albums, i, i+1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) addAlbumAssets(w http.ResponseWriter, r *http.Request) {
+	s.albumAssets(w, r, func(a *album, id string) string {
+		if slices.Contains(a.assets, id) {
+			return "duplicate"
+		}
+		a.assets = append(a.assets, id)
+		return ""
+	})
+}
+
+func (s *Server) removeAlbumAssets(w http.ResponseWriter, r *http.Request) {
+	s.albumAssets(w, r, func(a *album, id string) string {
+		i := slices.Index(a.assets, id)
+		if i < 0 {
+			return "not_found"
+		}
+		a.assets = slices.Delete(a.assets, i, i+1)
+		return ""
+	})
+}
+
+// albumAssets applies change to each asset of a BulkIdsDto and answers with
+// the BulkIdResponseDto of every id; change returns the error code.
+func (s *Server) albumAssets(w http.ResponseWriter, r *http.Request, change func(a *album, id string) string) {
+	var in struct {
+		IDs []string `json:"ids"`
+	}
+	if !readJSON(w, r, &in) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.album(r.PathValue("id"))
+	if a == nil {
+		writeError(w, http.StatusBadRequest, "Not found or no albumAsset.create access")
+		return
+	}
+	out := []map[string]any{}
+	for _, id := range in.IDs {
+		res := map[string]any{"id": id, "success": false}
+		if s.byID[id] == nil {
+			res["error"] = "not_found"
+		} else if code := change(a, id); code != "" {
+			res["error"] = code
+		} else {
+			res["success"] = true
+			a.updated = time.Now().UTC()
+		}
+		out = append(out, res)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) album(id string) *album {
+	for _, a := range s.albums {
+		if a.id == id {
+			return a
+		}
+	}
+	return nil
+}
+
+func (s *Server) albumJSON(a *album, withAssets bool) map[string]any {
+	assets := []map[string]any{}
+	if withAssets {
+		for _, id := range a.assets {
+			assets = append(assets, s.assetJSON(s.byID[id]))
+		}
+	}
+	var thumb any
+	if len(a.assets) > 0 {
+		thumb = a.assets[0]
+	}
+	return map[string]any{
+		"id":                    a.id,
+		"albumName":             a.name,
+		"description":           a.description,
+		"albumThumbnailAssetId": thumb,
+		"albumUsers":            []any{},
+		"assetCount":            len(a.assets),
+		"assets":                assets,
+		"owner":                 s.userJSON(),
+		"ownerId":               s.user.ID,
+		"shared":                false,
+		"hasSharedLink":         false,
+		"isActivityEnabled":     true,
+		"order":                 "desc",
+		"createdAt":             a.created,
+		"updatedAt":             a.updated,
+	}
+}
+
+// Assets
+
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	fields := map[string]string{}
+	var (
+		sum  string
+		size int64
+		name string
+	)
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if part.FormName() == "assetData" {
+			h := sha1.New()
+			if size, err = io.Copy(h, part); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			sum, name = hex.EncodeToString(h.Sum(nil)), part.FileName()
+			continue
+		}
+		b, err := io.ReadAll(io.LimitReader(part, 4<<10))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fields[part.FormName()] = string(b)
+	}
+	for _, k := range []string{"deviceAssetId", "deviceId", "fileCreatedAt", "fileModifiedAt"} {
+		if fields[k] == "" {
+			writeError(w, http.StatusBadRequest, k+" should not be empty")
+			return
+		}
+	}
+	if sum == "" {
+		writeError(w, http.StatusBadRequest, "assetData is required")
+		return
+	}
+	created, err1 := time.Parse(time.RFC3339Nano, fields["fileCreatedAt"])
+	modified, err2 := time.Parse(time.RFC3339Nano, fields["fileModifiedAt"])
+	if err := errors.Join(err1, err2); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if fields["filename"] != "" {
+		name = fields["filename"]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Like the server, trust the client's checksum header for the duplicate
+	// check, then the checksum of what was received.
+	if a := s.bySum[normalizeChecksum(r.Header.Get("x-immich-checksum"))]; a != nil {
+		writeJSON(w, http.StatusOK, map[string]any{"id": a.ID, "status": "duplicate"})
+		return
+	}
+	if a := s.bySum[sum]; a != nil {
+		writeJSON(w, http.StatusOK, map[string]any{"id": a.ID, "status": "duplicate"})
+		return
+	}
+	if s.used+size > s.DiskSize {
+		writeError(w, http.StatusInternalServerError, "ENOSPC: no space left on device, write")
+		return
+	}
+	a := &asset{
+		Asset: Asset{
+			ID:             newID(),
+			Checksum:       sum,
+			FileName:       name,
+			DeviceAssetID:  fields["deviceAssetId"],
+			DeviceID:       fields["deviceId"],
+			Size:           size,
+			FileCreatedAt:  created,
+			FileModifiedAt: modified,
+		},
+		uploaded: time.Now().UTC(),
+	}
+	s.add(a)
+	writeJSON(w, http.StatusCreated, map[string]any{"id": a.ID, "status": "created"})
+}
+
+func (s *Server) bulkUploadCheck(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Assets []struct {
+			ID       string `json:"id"`
+			Checksum string `json:"checksum"`
+		} `json:"assets"`
+	}
+	if !readJSON(w, r, &in) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := []map[string]any{}
+	for _, c := range in.Assets {
+		res := map[string]any{"id": c.ID, "action": "accept"}
+		if a := s.bySum[normalizeChecksum(c.Checksum)]; a != nil {
+			res["action"], res["reason"], res["assetId"], res["isTrashed"] = "reject", "duplicate", a.ID, false
+		}
+		results = append(results, res)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": results})
+}
+
+func (s *Server) getAsset(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.byID[r.PathValue("id")]
+	if a == nil {
+		writeError(w, http.StatusBadRequest, "Not found or no asset.read access")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.assetJSON(a))
+}
+
+func (s *Server) deleteAssets(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		IDs []string `json:"ids"`
+	}
+	if !readJSON(w, r, &in) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range in.IDs {
+		a := s.byID[id]
+		if a == nil {
+			continue
+		}
+		delete(s.byID, id)
+		delete(s.bySum, a.Checksum)
+		s.assets = slices.DeleteFunc(s.assets, func(b *asset) bool { return b == a })
+		s.used -= a.Size
+		for _, al := range s.albums {
+			al.assets = slices.DeleteFunc(al.assets, func(b string) bool { return b == id })
+		}
+		for _, st := range s.stacks {
+			st.assets = slices.DeleteFunc(st.assets, func(b string) bool { return b == id })
+		}
+	}
+	s.stacks = slices.DeleteFunc(s.stacks, func(st *stack) bool { return len(st.assets) < 2 })
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) assetJSON(a *asset) map[string]any {
+	typ := "IMAGE"
+	if isVideo(a.FileName) {
+		typ = "VIDEO"
+	}
+	out := map[string]any{
+		"id":               a.ID,
+		"type":             typ,
+		"checksum":         base64Checksum(a.Checksum),
+		"originalFileName": a.FileName,
+		"originalPath":     "upload/library/" + s.user.ID + "/" + a.ID + "/" + a.FileName,
+		"deviceAssetId":    a.DeviceAssetID,
+		"deviceId":         a.DeviceID,
+		"ownerId":          s.user.ID,
+		"fileCreatedAt":    a.FileCreatedAt,
+		"fileModifiedAt":   a.FileModifiedAt,
+		"localDateTime":    a.FileCreatedAt,
+		"createdAt":        a.uploaded,
+		"updatedAt":        a.uploaded,
+		"duration":         "0:00:00.00000",
+		"hasMetadata":      false,
+		"height":           nil,
+		"width":            nil,
+		"thumbhash":        nil,
+		"isArchived":       false,
+		"isEdited":         false,
+		"isFavorite":       false,
+		"isOffline":        false,
+		"isTrashed":        false,
+		"visibility":       "timeline",
+		"livePhotoVideoId": nil,
+	}
+	if st := s.stackOf(a.ID); st != nil {
+		out["stack"] = map[string]any{"id": st.id, "primaryAssetId": st.assets[0], "assetCount": len(st.assets)}
+	}
+	return out
+}
+
+// Stacks
+
+func (s *Server) listStacks(w http.ResponseWriter, r *http.Request) {
+	primary := r.URL.Query().Get("primaryAssetId")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []map[string]any{}
+	for _, st := range s.stacks {
+		if primary == "" || st.assets[0] == primary {
+			out = append(out, s.stackJSON(st))
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) createStack(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		AssetIDs []string `json:"assetIds"`
+	}
+	if !readJSON(w, r, &in) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for _, id := range in.AssetIDs {
+		if s.byID[id] == nil {
+			writeError(w, http.StatusBadRequest, "Not found or no asset.update access")
+			return
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < 2 {
+		writeError(w, http.StatusBadRequest, "assetIds must contain at least 2 elements")
+		return
+	}
+	// Assets already in a stack move to the new one.
+	for _, st := range s.stacks {
+		st.assets = slices.DeleteFunc(st.assets, func(id string) bool { return slices.Contains(ids, id) })
+	}
+	s.stacks = slices.DeleteFunc(s.stacks, func(st *stack) bool { return len(st.assets) < 2 })
+	st := &stack{id: newID(), assets: ids}
+	s.stacks = append(s.stacks, st)
+	writeJSON(w, http.StatusCreated, s.stackJSON(st))
+}
+
+func (s *Server) getStack(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stack(r.PathValue("id"))
+	if st == nil {
+		writeError(w, http.StatusBadRequest, "Not found or no stack.read access")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.stackJSON(st))
+}
+
+func (s *Server) updateStack(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		PrimaryAssetID string `json:"primaryAssetId"`
+	}
+	if !readJSON(w, r, &in) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stack(r.PathValue("id"))
+	if st == nil {
+		writeError(w, http.StatusBadRequest, "Not found or no stack.update access")
+		return
+	}
+	if in.PrimaryAssetID != "" {
+		i := slices.Index(st.assets, in.PrimaryAssetID)
+		if i < 0 {
+			writeError(w, http.StatusBadRequest, "Primary asset must be in the stack")
+			return
+		}
+		st.assets[0], st.assets[i] = st.assets[i], st.assets[0]
+	}
+	writeJSON(w, http.StatusOK, s.stackJSON(st))
+}
+
+func (s *Server) deleteStack(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stack(r.PathValue("id")) == nil {
+		writeError(w, http.StatusBadRequest, "Not found or no stack.delete access")
+		return
+	}
+	s.stacks = slices.DeleteFunc(s.stacks, func(st *stack) bool { return st.id == r.PathValue("id") })
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteStacks(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		IDs []string `json:"ids"`
+	}
+	if !readJSON(w, r, &in) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stacks = slices.DeleteFunc(s.stacks, func(st *stack) bool { return slices.Contains(in.IDs, st.id) })
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) stack(id string) *stack {
+	for _, st := range s.stacks {
+		if st.id == id {
+			return st
+		}
+	}
+	return nil
+}
+
+func (s *Server) stackOf(assetID string) *stack {
+	for _, st := range s.stacks {
+		if slices.Contains(st.assets, assetID) {
+			return st
+		}
+	}
+	return nil
+}
+
+func (s *Server) stackJSON(st *stack) map[string]any {
+	assets := []map[string]any{}
+	for _, id := range st.assets {
+		assets = append(assets, s.assetJSON(s.byID[id]))
+	}
+	return map[string]any{"id": st.id, "primaryAssetId": st.assets[0], "assets": assets}
+}
+
+// JSON helpers
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<20)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", fmt.Sprint(len(b)))
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
+
+// writeError answers like the server's exception filter.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]any{
+		"message":       msg,
+		"error":         http.StatusText(status),
+		"statusCode":    status,
+		"correlationId": newID()[:8],
+	})
+}
+
+// normalizeChecksum returns a hex or base64 SHA-1 as lowercase hex ("" if
+// it is neither).
+func normalizeChecksum(c string) string {
+	if len(c) == 40 {
+		if _, err := hex.DecodeString(c); err == nil {
+			return strings.ToLower(c)
+		}
+	}
+	if b, err := base64.StdEncoding.DecodeString(c); err == nil && len(b) == sha1.Size {
+		return hex.EncodeToString(b)
+	}
+	return ""
+}
+
+func base64Checksum(hexSum string) string {
+	b, _ := hex.DecodeString(hexSum)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func isVideo(name string) bool {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return false
+	}
+	switch strings.ToLower(name[i:]) {
+	case ".mp4", ".mov", ".m4v", ".mkv", ".avi", ".webm":
+		return true
+	}
+	return false
+}
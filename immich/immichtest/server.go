@@ -0,0 +1,197 @@
+// Package immichtest provides an in-memory fake Immich server for tests and
+// offline runs.
+//
+// It implements the endpoints the uploader uses (server info, the current
+// user, albums, assets, the bulk upload check and stacks) under /api, with
+// the v2 paths and response shapes of the bundled OpenAPI spec. Uploads are
+// deduplicated by SHA-1 checksum like the real server, and faults (latency,
+// error statuses, rate limiting, dropped connections) can be injected per
+// endpoint.
+//
+//	srv := immichtest.NewServer()
+//	defer srv.Close()
+//	c := immich.New(srv.URL, immich.APIKey(srv.APIKey))
+package immichtest
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"immich-uploader/immich"
+)
+
+// DefaultAPIKey is the API key a new server accepts.
+const DefaultAPIKey = "immichtest-key"
+
+// Server is a fake Immich server. Its exported fields may be changed before
+// the first request; its state is read and seeded through methods, which are
+// safe for concurrent use with requests.
+type Server struct {
+	// URL is the API root (ending in /api) once the server is started.
+	URL string
+	// APIKey is the only x-api-key accepted; empty accepts any request.
+	APIKey string
+	// Version is reported by /server/version.
+	Version immich.ServerVersion
+	// DiskSize is the server's disk in bytes. Uploads that don't fit fail
+	// like a full disk does (500 ENOSPC).
+	DiskSize int64
+	// Logf, if set, receives a line per request.
+	Logf func(format string, args ...any)
+
+	mux *http.ServeMux
+	ts  *httptest.Server
+
+	mu       sync.Mutex
+	user     immich.User
+	start    time.Time
+	albums   []*album
+	assets   []*asset
+	byID     map[string]*asset
+	bySum    map[string]*asset // hex sha1
+	stacks   []*stack
+	used     int64
+	faults   []*Fault
+	requests []Request
+}
+
+// Request is a request the server received.
+type Request struct {
+	Method string
+	Path   string // under /api, e.g. /albums/{id}/assets with the actual id
+	Status int    // 0 if the connection was dropped
+}
+
+// New returns a server that is not listening yet: use it as an
+// http.Handler, or call Start.
+func New() *Server {
+	s := &Server{
+		APIKey:   DefaultAPIKey,
+		Version:  immich.ServerVersion{Major: 2, Minor: 5, Patch: 2},
+		DiskSize: 1 << 40,
+		user:     immich.User{ID: newID(), Name: "Test User", Email: "test@example.com"},
+		start:    time.Now().UTC(),
+		byID:     map[string]*asset{},
+		bySum:    map[string]*asset{},
+	}
+	s.routes()
+	return s
+}
+
+// NewServer returns a started server. Close it when done.
+func NewServer() *Server {
+	s := New()
+	s.Start()
+	return s
+}
+
+// Start serves s on a local port and sets URL.
+func (s *Server) Start() {
+	s.ts = httptest.NewServer(s)
+	s.URL = s.ts.URL + "/api"
+}
+
+// Close shuts down a server started with Start or NewServer.
+func (s *Server) Close() {
+	if s.ts != nil {
+		s.ts.Close()
+	}
+}
+
+// ServeHTTP serves the API under /api.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+	rec := &recorder{ResponseWriter: w}
+	path := strings.TrimPrefix(r.URL.Path, "/api")
+	defer func() {
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{Method: r.Method, Path: path, Status: rec.status})
+		s.mu.Unlock()
+		if s.Logf != nil {
+			s.Logf("%s %s %s (%s)\n", r.Method, r.URL.Path, statusText(rec.status), time.Since(begin).Round(time.Millisecond))
+		}
+	}()
+
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		writeError(rec, http.StatusNotFound, "Cannot "+r.Method+" "+r.URL.Path)
+		return
+	}
+	if s.inject(rec, r, path) {
+		return
+	}
+	if !public(path) && s.APIKey != "" && r.Header.Get("x-api-key") != s.APIKey {
+		writeError(rec, http.StatusUnauthorized, "Invalid API key")
+		return
+	}
+	s.mux.ServeHTTP(rec, r)
+}
+
+// public reports whether path is served without authentication.
+func public(path string) bool {
+	switch path {
+	case "/server/ping", "/server/version", "/server/features", "/server/media-types", "/server/about":
+		return true
+	}
+	return false
+}
+
+// Requests returns the requests received so far, oldest first.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Count returns how many requests matched method and pattern (see Fault).
+func (s *Server) Count(method, pattern string) int {
+	n := 0
+	for _, r := range s.Requests() {
+		if matches(method, pattern, r.Method, r.Path) {
+			n++
+		}
+	}
+	return n
+}
+
+// recorder remembers the status of a response.
+type recorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+func statusText(code int) string {
+	if code == 0 {
+		return "dropped"
+	}
+	return fmt.Sprint(code)
+}
+
+// newID returns a random UUID, the id format of every Immich resource.
+func newID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
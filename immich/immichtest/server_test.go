@@ -0,0 +1,179 @@
+package immichtest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"immich-uploader/immich"
+	"immich-uploader/immich/immichtest"
+)
+
+func TestStacks(t *testing.T) {
+	srv := immichtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	c := immich.New(srv.URL, immich.APIKey(srv.APIKey))
+	raw := srv.AddAsset("IMG_1.dng", []byte("raw"))
+	jpg := srv.AddAsset("IMG_1.jpg", []byte("jpeg"))
+	other := srv.AddAsset("IMG_2.jpg", []byte("other"))
+
+	st, err := c.CreateStack(ctx, []string{jpg.ID, raw.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.PrimaryAssetID != jpg.ID || len(st.Assets) != 2 {
+		t.Fatalf("stack = %+v, want %s primary of 2 assets", st, jpg.ID)
+	}
+	if st, err = c.SetStackPrimary(ctx, st.ID, raw.ID); err != nil || st.PrimaryAssetID != raw.ID {
+		t.Fatalf("SetStackPrimary = %+v, %v; want %s primary", st, err, raw.ID)
+	}
+	if got, err := c.ListStacks(ctx, raw.ID); err != nil || len(got) != 1 || got[0].ID != st.ID {
+		t.Fatalf("ListStacks(%s) = %+v, %v; want the stack", raw.ID, got, err)
+	}
+
+	// Stacking an asset again moves it out of its stack, which then has one
+	// asset left and is removed.
+	moved, err := c.CreateStack(ctx, []string{other.ID, jpg.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Stacks(); len(got) != 1 || got[0].ID != moved.ID {
+		t.Fatalf("stacks = %+v, want only %s", got, moved.ID)
+	}
+	// Like the server, a missing resource is a 400.
+	var se *immich.StatusError
+	if _, err := c.GetStack(ctx, st.ID); !errors.As(err, &se) || se.Code != 400 {
+		t.Errorf("GetStack of the removed stack: %v, want status 400", err)
+	}
+	if err := c.DeleteStack(ctx, moved.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Stacks(); len(got) != 0 {
+		t.Errorf("stacks = %+v after delete", got)
+	}
+}
+
+func TestUploadDedupe(t *testing.T) {
+	srv := immichtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	c := immich.New(srv.URL, immich.APIKey(srv.APIKey))
+	upload := func(name, data, checksum string) immich.UploadResult {
+		t.Helper()
+		res, err := c.UploadAsset(ctx, immich.AssetUpload{
+			DeviceAssetID:  name,
+			DeviceID:       "test",
+			FileCreatedAt:  time.Now(),
+			FileModifiedAt: time.Now(),
+			Filename:       name,
+			Checksum:       checksum,
+			Open:           func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(data)), nil },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	first := upload("a.jpg", "same", "")
+	if first.Status != immich.StatusCreated {
+		t.Fatalf("first upload: %+v, want created", first)
+	}
+	// By content, and by the checksum header (hex sha1 of "same").
+	if res := upload("b.jpg", "same", ""); res.Status != immich.StatusDuplicate || res.ID != first.ID {
+		t.Errorf("same content: %+v, want duplicate of %s", res, first.ID)
+	}
+	if res := upload("c.jpg", "ignored", "4ff6d5b4e2ad6d4ea4b2b1b3ac1b5d0a4b1b1e2e"); res.Status != immich.StatusCreated {
+		t.Errorf("unknown checksum header: %+v, want created", res)
+	}
+	sum := srv.Assets()[0].Checksum
+	if res := upload("d.jpg", "different", sum); res.Status != immich.StatusDuplicate || res.ID != first.ID {
+		t.Errorf("known checksum header: %+v, want duplicate of %s", res, first.ID)
+	}
+
+	checks, err := c.BulkUploadCheck(ctx, []immich.UploadCheck{{ID: "1", Checksum: sum}, {ID: "2", Checksum: "0000000000000000000000000000000000000000"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 2 || checks[0].Action != "reject" || checks[0].AssetID != first.ID || checks[1].Action != "accept" {
+		t.Errorf("BulkUploadCheck = %+v, want 1 rejected as %s, 2 accepted", checks, first.ID)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	srv := immichtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	c := immich.New(srv.URL, immich.APIKey(srv.APIKey))
+	// net/http silently retries a GET whose reused connection was dropped.
+	c.HTTP = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	for _, s := range []string{"GET /albums 503 x1", "GET /albums drop x1"} {
+		f, err := immichtest.ParseFault(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.Inject(f)
+	}
+	if _, err := c.ListAlbums(ctx, nil); !errors.Is(err, immich.ErrServer) {
+		t.Errorf("with 503: %v, want ErrServer", err)
+	}
+	if _, err := c.ListAlbums(ctx, nil); !errors.Is(err, immich.ErrNetwork) {
+		t.Errorf("with a dropped connection: %v, want ErrNetwork", err)
+	}
+	if _, err := c.ListAlbums(ctx, nil); err != nil {
+		t.Errorf("after the faults: %v", err)
+	}
+
+	srv.Inject(immichtest.Fault{Path: "/albums", Latency: 50 * time.Millisecond, Times: 1})
+	start := time.Now()
+	if _, err := c.ListAlbums(ctx, nil); err != nil || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("with latency: %v after %s", err, time.Since(start))
+	}
+
+	want := []int{503, 0, 200, 200}
+	var got []int
+	for _, r := range srv.Requests() {
+		got = append(got, r.Status)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+}
+
+func TestParseFault(t *testing.T) {
+	for s, want := range map[string]immichtest.Fault{
+		"POST /assets 503 x2":       {Method: "POST", Path: "/assets", Status: 503, Times: 2},
+		"* 200ms":                   {Path: "*", Latency: 200 * time.Millisecond},
+		"get /albums/*/assets drop": {Method: "GET", Path: "/albums/*/assets", Drop: true},
+		"/assets 429":               {Path: "/assets", Status: 429, RetryAfter: time.Second},
+	} {
+		got, err := immichtest.ParseFault(s)
+		if err != nil || got != want {
+			t.Errorf("ParseFault(%q) = %+v, %v; want %+v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "/assets", "/assets 200", "/assets slow", "GET /assets 503 x0", "a b c d"} {
+		if _, err := immichtest.ParseFault(s); err == nil {
+			t.Errorf("ParseFault(%q) succeeded", s)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	srv := immichtest.NewServer()
+	defer srv.Close()
+	c := immich.New(srv.URL, immich.APIKey("wrong"))
+	if _, err := c.ListAlbums(context.Background(), nil); !errors.Is(err, immich.ErrAuth) {
+		t.Errorf("wrong key: %v, want ErrAuth", err)
+	}
+	if err := c.Ping(context.Background()); err != nil {
+		t.Errorf("ping needs no key: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package immichtest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"slices"
+	"time"
+)
+
+// Asset is an asset stored on the server.
+type Asset struct {
+	ID             string
+	Checksum       string // hex sha1 of the contents
+	FileName       string
+	DeviceAssetID  string
+	DeviceID       string
+	Size           int64
+	FileCreatedAt  time.Time
+	FileModifiedAt time.Time
+}
+
+// Album is an album and the ids of its assets, in the order they were added.
+type Album struct {
+	ID       string
+	Name     string
+	AssetIDs []string
+}
+
+// Stack is a stack; its first asset is the primary one.
+type Stack struct {
+	ID       string
+	AssetIDs []string
+}
+
+type asset struct {
+	Asset
+	uploaded time.Time
+}
+
+type album struct {
+	id, name, description string
+	assets                []string
+	created, updated      time.Time
+}
+
+type stack struct {
+	id     string
+	assets []string
+}
+
+// add stores a. s.mu must be held.
+func (s *Server) add(a *asset) {
+	s.assets = append(s.assets, a)
+	s.byID[a.ID] = a
+	s.bySum[a.Checksum] = a
+	s.used += a.Size
+}
+
+// Assets returns the stored assets in upload order.
+func (s *Server) Assets() []Asset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Asset, len(s.assets))
+	for i, a := range s.assets {
+		out[i] = a.Asset
+	}
+	return out
+}
+
+// Albums returns the albums in creation order.
+func (s *Server) Albums() []Album {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Album, len(s.albums))
+	for i, a := range s.albums {
+		out[i] = Album{ID: a.id, Name: a.name, AssetIDs: slices.Clone(a.assets)}
+	}
+	return out
+}
+
+// Album returns the first album named name.
+func (s *Server) Album(name string) (Album, bool) {
+	for _, a := range s.Albums() {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Album{}, false
+}
+
+// Stacks returns the stacks in creation order.
+func (s *Server) Stacks() []Stack {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Stack, len(s.stacks))
+	for i, st := range s.stacks {
+		out[i] = Stack{ID: st.id, AssetIDs: slices.Clone(st.assets)}
+	}
+	return out
+}
+
+// AddAsset stores a file as if it had been uploaded, e.g. to test
+// duplicates, and returns it. A file the server already has is returned as
+// it is.
+func (s *Server) AddAsset(name string, data []byte) Asset {
+	h := sha1.Sum(data)
+	sum := hex.EncodeToString(h[:])
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a := s.bySum[sum]; a != nil {
+		return a.Asset
+	}
+	now := time.Now().UTC()
+	a := &asset{
+		Asset: Asset{
+			ID:             newID(),
+			Checksum:       sum,
+			FileName:       name,
+			DeviceAssetID:  name + "-" + sum[:8],
+			DeviceID:       "immichtest",
+			Size:           int64(len(data)),
+			FileCreatedAt:  now,
+			FileModifiedAt: now,
+		},
+		uploaded: now,
+	}
+	s.add(a)
+	return a.Asset
+}
+
+// AddAlbum creates an album holding the given assets and returns it.
+func (s *Server) AddAlbum(name string, assetIDs ...string) Album {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	a := &album{id: newID(), name: name, created: now, updated: now}
+	for _, id := range assetIDs {
+		if s.byID[id] != nil && !slices.Contains(a.assets, id) {
+			a.assets = append(a.assets, id)
+		}
+	}
+	s.albums = append(s.albums, a)
+	return Album{ID: a.id, Name: a.name, AssetIDs: slices.Clone(a.assets)}
+}
@@ -0,0 +1,59 @@
+package immich
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Search:
+// - POST /search/metadata  (MetadataSearchDto -> SearchResponseDto)
+
+// MetadataSearch is MetadataSearchDto: every set field must match. Results
+// come in pages of Size (default 250); Page starts at 1.
+type MetadataSearch struct {
+	Checksum         string     `json:"checksum,omitempty"` // base64 sha1
+	DeviceID         string     `json:"deviceId,omitempty"`
+	DeviceAssetID    string     `json:"deviceAssetId,omitempty"`
+	OriginalFileName string     `json:"originalFileName,omitempty"`
+	OriginalPath     string     `json:"originalPath,omitempty"`
+	Description      string     `json:"description,omitempty"`
+	Type             string     `json:"type,omitempty"` // IMAGE, VIDEO, AUDIO, OTHER
+	Visibility       string     `json:"visibility,omitempty"`
+	IsFavorite       *bool      `json:"isFavorite,omitempty"`
+	IsNotInAlbum     *bool      `json:"isNotInAlbum,omitempty"`
+	AlbumIDs         []string   `json:"albumIds,omitempty"`
+	TagIDs           []string   `json:"tagIds,omitempty"`
+	TakenAfter       *time.Time `json:"takenAfter,omitempty"`
+	TakenBefore      *time.Time `json:"takenBefore,omitempty"`
+	CreatedAfter     *time.Time `json:"createdAfter,omitempty"`
+	CreatedBefore    *time.Time `json:"createdBefore,omitempty"`
+	WithDeleted      bool       `json:"withDeleted,omitempty"`
+	WithExif         bool       `json:"withExif,omitempty"`
+	WithStacked      bool       `json:"withStacked,omitempty"`
+	Order            string     `json:"order,omitempty"` // asc or desc
+	Page             int        `json:"page,omitempty"`
+	Size             int        `json:"size,omitempty"`
+}
+
+// SearchResult is the asset half of SearchResponseDto. NextPage is nil on
+// the last page.
+type SearchResult struct {
+	Items    []Asset `json:"items"`
+	Count    int     `json:"count"`
+	Total    int     `json:"total"`
+	NextPage *string `json:"nextPage"`
+}
+
+// SearchMetadata returns one page of the assets matching q.
+func (c *Client) SearchMetadata(ctx context.Context, q MetadataSearch) (SearchResult, error) {
+	var out struct {
+		Assets SearchResult `json:"assets"`
+	}
+	path, err := c.path(c.api().SearchMetadata)
+	if err != nil {
+		return out.Assets, err
+	}
+	err = c.do(ctx, call{method: http.MethodPost, path: path, in: q, out: &out})
+	return out.Assets, err
+}
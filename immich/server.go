@@ -0,0 +1,130 @@
+package immich
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Server:
+// - GET /server/ping         (ServerPingResponse)
+// - GET /server/version      (ServerVersionResponseDto)
+// - GET /server/about        (ServerAboutResponseDto)
+// - GET /server/features     (ServerFeaturesDto)
+// - GET /server/media-types  (ServerMediaTypesResponseDto)
+// - GET /server/storage      (ServerStorageResponseDto)
+
+// ErrNotImmich is returned by Ping when the URL answers, but not as an
+// Immich API.
+var ErrNotImmich = errors.New("not an Immich API")
+
+// Ping checks that the server answers /server/ping with "pong".
+func (c *Client) Ping(ctx context.Context) error {
+	path, err := c.path(c.api().Ping)
+	if err != nil {
+		return err
+	}
+	var out struct {
+		Res string `json:"res"`
+	}
+	if err := c.do(ctx, call{method: http.MethodGet, path: path, out: &out, anonymous: true}); err != nil {
+		return err
+	}
+	if out.Res != "pong" {
+		return ErrNotImmich
+	}
+	return nil
+}
+
+// Version returns the server release.
+func (c *Client) Version(ctx context.Context) (ServerVersion, error) {
+	var out ServerVersion
+	path, err := c.path(c.api().Version)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodGet, path: path, out: &out})
+	return out, err
+}
+
+// ServerAbout is part of ServerAboutResponseDto.
+type ServerAbout struct {
+	Version    string `json:"version"`
+	VersionURL string `json:"versionUrl"`
+	Licensed   bool   `json:"licensed"`
+	Build      string `json:"build,omitempty"`
+	Nodejs     string `json:"nodejs,omitempty"`
+	FFmpeg     string `json:"ffmpeg,omitempty"`
+	Libvips    string `json:"libvips,omitempty"`
+	Exiftool   string `json:"exiftool,omitempty"`
+}
+
+func (c *Client) About(ctx context.Context) (ServerAbout, error) {
+	var out ServerAbout
+	path, err := c.path(c.api().About)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodGet, path: path, out: &out})
+	return out, err
+}
+
+// ServerFeatures is ServerFeaturesDto.
+type ServerFeatures struct {
+	DuplicateDetection bool `json:"duplicateDetection"`
+	FacialRecognition  bool `json:"facialRecognition"`
+	Map                bool `json:"map"`
+	OAuth              bool `json:"oauth"`
+	PasswordLogin      bool `json:"passwordLogin"`
+	ReverseGeocoding   bool `json:"reverseGeocoding"`
+	Search             bool `json:"search"`
+	Sidecar            bool `json:"sidecar"`
+	SmartSearch        bool `json:"smartSearch"`
+	Trash              bool `json:"trash"`
+}
+
+func (c *Client) Features(ctx context.Context) (ServerFeatures, error) {
+	var out ServerFeatures
+	path, err := c.path(c.api().Features)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodGet, path: path, out: &out})
+	return out, err
+}
+
+// MediaTypes is ServerMediaTypesResponseDto: the file extensions (".jpg")
+// the server accepts.
+type MediaTypes struct {
+	Image   []string `json:"image"`
+	Video   []string `json:"video"`
+	Sidecar []string `json:"sidecar"`
+}
+
+func (c *Client) MediaTypes(ctx context.Context) (MediaTypes, error) {
+	var out MediaTypes
+	path, err := c.path(c.api().MediaTypes)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodGet, path: path, out: &out})
+	return out, err
+}
+
+// ServerStorage is part of ServerStorageResponseDto, in bytes.
+type ServerStorage struct {
+	DiskSizeRaw      int64 `json:"diskSizeRaw"`
+	DiskUseRaw       int64 `json:"diskUseRaw"`
+	DiskAvailableRaw int64 `json:"diskAvailableRaw"`
+}
+
+// Storage returns the server's disk usage. API keys need server.storage.
+func (c *Client) Storage(ctx context.Context) (ServerStorage, error) {
+	var out ServerStorage
+	path, err := c.path(c.api().Storage)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodGet, path: path, out: &out})
+	return out, err
+}
@@ -0,0 +1,95 @@
+package immich
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Stacks (several assets shown as one, e.g. RAW+JPEG or a burst):
+// - GET    /stacks       (StackResponseDto[])
+// - POST   /stacks       (StackCreateDto -> StackResponseDto)
+// - DELETE /stacks       (BulkIdsDto)
+// - GET    /stacks/{id}  (StackResponseDto)
+// - PUT    /stacks/{id}  (StackUpdateDto -> StackResponseDto)
+// - DELETE /stacks/{id}
+
+// Stack is StackResponseDto.
+type Stack struct {
+	ID             string  `json:"id"`
+	PrimaryAssetID string  `json:"primaryAssetId"`
+	Assets         []Asset `json:"assets"`
+}
+
+// ListStacks returns the user's stacks, or only the one whose primary asset
+// is primaryAssetID if that is set.
+func (c *Client) ListStacks(ctx context.Context, primaryAssetID string) ([]Stack, error) {
+	path, err := c.path(c.api().Stacks)
+	if err != nil {
+		return nil, err
+	}
+	var q url.Values
+	if primaryAssetID != "" {
+		q = url.Values{"primaryAssetId": {primaryAssetID}}
+	}
+	var out []Stack
+	err = c.do(ctx, call{method: http.MethodGet, path: path, query: q, out: &out})
+	return out, err
+}
+
+// CreateStack stacks assets; the first one becomes the primary asset.
+// Assets already in a stack are moved to the new one.
+func (c *Client) CreateStack(ctx context.Context, assetIDs []string) (Stack, error) {
+	var out Stack
+	path, err := c.path(c.api().Stacks)
+	if err != nil {
+		return out, err
+	}
+	in := struct {
+		AssetIDs []string `json:"assetIds"`
+	}{assetIDs}
+	err = c.do(ctx, call{method: http.MethodPost, path: path, in: in, out: &out})
+	return out, err
+}
+
+func (c *Client) GetStack(ctx context.Context, id string) (Stack, error) {
+	var out Stack
+	path, err := c.path(c.api().Stack, id)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodGet, path: path, out: &out})
+	return out, err
+}
+
+// SetStackPrimary makes one of the stack's assets its primary asset.
+func (c *Client) SetStackPrimary(ctx context.Context, id, primaryAssetID string) (Stack, error) {
+	var out Stack
+	path, err := c.path(c.api().Stack, id)
+	if err != nil {
+		return out, err
+	}
+	in := struct {
+		PrimaryAssetID string `json:"primaryAssetId"`
+	}{primaryAssetID}
+	err = c.do(ctx, call{method: http.MethodPut, path: path, in: in, out: &out})
+	return out, err
+}
+
+// DeleteStack unstacks the assets; the assets themselves are kept.
+func (c *Client) DeleteStack(ctx context.Context, id string) error {
+	path, err := c.path(c.api().Stack, id)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, call{method: http.MethodDelete, path: path})
+}
+
+// DeleteStacks unstacks several stacks at once.
+func (c *Client) DeleteStacks(ctx context.Context, ids []string) error {
+	path, err := c.path(c.api().Stacks)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, call{method: http.MethodDelete, path: path, in: bulkIDs{IDs: ids}})
+}
@@ -0,0 +1,124 @@
+package immich
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Tags (hierarchical, "Trips/2024/Rome"):
+// - GET    /tags              (TagResponseDto[])
+// - POST   /tags              (TagCreateDto -> TagResponseDto)
+// - PUT    /tags              (TagUpsertDto -> TagResponseDto[])
+// - GET    /tags/{id}         (TagResponseDto)
+// - DELETE /tags/{id}
+// - PUT    /tags/{id}/assets  (BulkIdsDto -> BulkIdResponseDto[])
+// - DELETE /tags/{id}/assets  (BulkIdsDto -> BulkIdResponseDto[])
+// - PUT    /tags/assets       (TagBulkAssetsDto -> TagBulkAssetsResponseDto)
+
+// Tag is TagResponseDto. Value is the full path, Name its last element.
+type Tag struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Value     string    `json:"value"`
+	ParentID  string    `json:"parentId,omitempty"`
+	Color     string    `json:"color,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CreateTag is TagCreateDto.
+type CreateTag struct {
+	Name     string  `json:"name"`
+	ParentID *string `json:"parentId,omitempty"`
+	Color    string  `json:"color,omitempty"`
+}
+
+func (c *Client) ListTags(ctx context.Context) ([]Tag, error) {
+	path, err := c.path(c.api().Tags)
+	if err != nil {
+		return nil, err
+	}
+	var out []Tag
+	err = c.do(ctx, call{method: http.MethodGet, path: path, out: &out})
+	return out, err
+}
+
+func (c *Client) CreateTag(ctx context.Context, in CreateTag) (Tag, error) {
+	var out Tag
+	path, err := c.path(c.api().Tags)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodPost, path: path, in: in, out: &out})
+	return out, err
+}
+
+// UpsertTags returns the tags with the given paths ("Trips/2024"), creating
+// those (and their parents) that don't exist yet.
+func (c *Client) UpsertTags(ctx context.Context, values []string) ([]Tag, error) {
+	path, err := c.path(c.api().Tags)
+	if err != nil {
+		return nil, err
+	}
+	in := struct {
+		Tags []string `json:"tags"`
+	}{values}
+	var out []Tag
+	err = c.do(ctx, call{method: http.MethodPut, path: path, in: in, out: &out})
+	return out, err
+}
+
+func (c *Client) GetTag(ctx context.Context, id string) (Tag, error) {
+	var out Tag
+	path, err := c.path(c.api().Tag, id)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodGet, path: path, out: &out})
+	return out, err
+}
+
+func (c *Client) DeleteTag(ctx context.Context, id string) error {
+	path, err := c.path(c.api().Tag, id)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, call{method: http.MethodDelete, path: path})
+}
+
+func (c *Client) TagAssets(ctx context.Context, tagID string, assetIDs []string) ([]BulkIDResult, error) {
+	return c.tagAssets(ctx, http.MethodPut, tagID, assetIDs)
+}
+
+func (c *Client) UntagAssets(ctx context.Context, tagID string, assetIDs []string) ([]BulkIDResult, error) {
+	return c.tagAssets(ctx, http.MethodDelete, tagID, assetIDs)
+}
+
+func (c *Client) tagAssets(ctx context.Context, method, tagID string, assetIDs []string) ([]BulkIDResult, error) {
+	path, err := c.path(c.api().TagAssets, tagID)
+	if err != nil {
+		return nil, err
+	}
+	var out []BulkIDResult
+	err = c.do(ctx, call{method: method, path: path, in: bulkIDs{IDs: assetIDs}, out: &out})
+	return out, err
+}
+
+// BulkTagAssets puts every tag on every asset and returns how many tag
+// assignments were made.
+func (c *Client) BulkTagAssets(ctx context.Context, tagIDs, assetIDs []string) (int, error) {
+	path, err := c.path(c.api().BulkTagAssets)
+	if err != nil {
+		return 0, err
+	}
+	in := struct {
+		TagIDs   []string `json:"tagIds"`
+		AssetIDs []string `json:"assetIds"`
+	}{tagIDs, assetIDs}
+	var out struct {
+		Count int `json:"count"`
+	}
+	err = c.do(ctx, call{method: http.MethodPut, path: path, in: in, out: &out})
+	return out.Count, err
+}
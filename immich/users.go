@@ -0,0 +1,60 @@
+package immich
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Users:
+// - GET /users/me     (UserAdminResponseDto)
+// - GET /api-keys/me  (APIKeyResponseDto)
+
+// User is UserResponseDto, the public part of a user.
+type User struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// UserAdmin is UserAdminResponseDto, the user's own profile. A nil
+// QuotaSizeInBytes means no quota.
+type UserAdmin struct {
+	User
+	IsAdmin           bool      `json:"isAdmin"`
+	StorageLabel      *string   `json:"storageLabel"`
+	QuotaSizeInBytes  *int64    `json:"quotaSizeInBytes"`
+	QuotaUsageInBytes *int64    `json:"quotaUsageInBytes"`
+	Status            string    `json:"status"`
+	CreatedAt         time.Time `json:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+// Me returns the authenticated user.
+func (c *Client) Me(ctx context.Context) (UserAdmin, error) {
+	var out UserAdmin
+	path, err := c.path(c.api().UsersMe)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodGet, path: path, out: &out})
+	return out, err
+}
+
+// APIKeyInfo is APIKeyResponseDto.
+type APIKeyInfo struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"` // "all" or e.g. "asset.upload"
+}
+
+// APIKeyInfo returns the name and permissions of the API key in use.
+func (c *Client) APIKeyInfo(ctx context.Context) (APIKeyInfo, error) {
+	var out APIKeyInfo
+	path, err := c.path(c.api().APIKeysMe)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(ctx, call{method: http.MethodGet, path: path, out: &out})
+	return out, err
+}
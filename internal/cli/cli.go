@@ -0,0 +1,256 @@
+// Package cli is the immich-uploader command line. The root command and
+// cmd/cli are both this package, so every build of the command behaves the
+// same.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"golang.org/x/term"
+
+	"immich-uploader/internal/config"
+	"immich-uploader/internal/daemon"
+	"immich-uploader/internal/uploader"
+)
+
+const usage = `Usage: %[1]s [command] [flags]
+
+Commands:
+  upload       Upload the album folders under --root (the default)
+  verify       Check that the files in the ignore folder are on the server and in their album
+  doctor       Check the connection, credentials and root without uploading
+  fake-server  Serve an in-memory fake Immich API for trying things out
+  help         Show this help
+
+Run "%[1]s <command> --help" for the flags of a command.
+`
+
+// Main runs the command with args (without the program name) and returns the
+// exit code: 0 on success, 1 when the command failed, 2 for usage errors.
+func Main(args []string) int {
+	cmd := "upload"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "upload", "verify", "doctor":
+		return run(cmd, args)
+	case "fake-server":
+		return fakeServer(args)
+	case "help":
+		fmt.Printf(usage, os.Args[0])
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		return 2
+	}
+}
+
+// summaries are the one-line descriptions of the commands sharing run's flags.
+var summaries = map[string]string{
+	"upload": "Upload the album folders under --root into albums named after them, moving\nuploaded files into the ignore folder.",
+	"verify": "Check that the files already moved into the ignore folder are on the server and\nin the album named after their folder. Nothing is uploaded or moved.",
+	"doctor": "Check the connection, credentials and root without uploading.",
+}
+
+// run parses the flags shared by upload, verify and doctor, applies them over
+// the config file and environment, and runs cmd.
+func run(cmd string, args []string) int {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n\n%s\n\n", os.Args[0], cmd, summaries[cmd])
+		fs.PrintDefaults()
+	}
+	d := config.Defaults()
+	var (
+		configPath = fs.String("config", "", "Config file (default: $IMMICH_CONFIG or "+config.DefaultPath()+")")
+		profile    = fs.String("profile", "", "Config profile to use (default: $IMMICH_PROFILE or the file's \"profile\" key)")
+	)
+	// Every other flag is named after its config key and only overrides the
+	// config file and environment when given on the command line.
+	fs.String("immich", d.BaseURL, "Immich base API URL (include /api). Example: https://photos.example.com/api")
+	fs.String("key", d.APIKey, "Immich API key (x-api-key; visible in ps and shell history, prefer --key-file or IMMICH_API_KEY)")
+	fs.String("key-file", d.KeyFile, "Read the API key from this file (- = stdin)")
+	fs.String("key-command", d.KeyCommand, "Read the API key from the output of this command, e.g. \"pass show immich\"")
+	fs.String("email", d.Email, "Log in with this email and a password instead of an API key")
+	fs.String("password-file", d.PasswordFile, "Read the login password from this file (- = stdin); or set IMMICH_PASSWORD")
+	fs.String("shared-link", d.SharedLink, "Upload into the album of this shared link (key or https://host/share/<key>) instead of using an API key")
+	fs.String("shared-link-password", d.SharedLinkPassword, "Password of a password-protected shared link")
+	fs.String("ca-cert", d.CACert, "PEM bundle of a private CA to trust in addition to the system roots")
+	fs.String("client-cert", d.ClientCert, "PEM client certificate for mTLS (with --client-key)")
+	fs.String("client-key", d.ClientKey, "PEM private key of --client-cert")
+	fs.Bool("insecure", d.Insecure, "Do not verify the server's TLS certificate (testing only)")
+	fs.String("proxy", d.Proxy, "HTTP or SOCKS proxy URL, e.g. socks5://127.0.0.1:1080 (default: HTTPS_PROXY/HTTP_PROXY)")
+	fs.Var((*listFlag)(&d.Header), "header", "Extra \"Name: value\" header sent with every request (repeatable), e.g. for Cloudflare Access")
+	fs.Bool("trace", d.Trace, "Log every HTTP request and response (credentials redacted, bodies truncated)")
+	fs.String("har", d.HAR, "Write the HTTP requests and responses of the run to this HAR file (open it in browser dev tools)")
+	fs.String("validate-openapi", d.ValidateOpenAPI, "Check requests and responses against the bundled Immich OpenAPI spec: warn|strict (default off)")
+	fs.String("root", d.Root, "Root folder containing album folders")
+	fs.Bool("deep", d.Deep, "If true (default), upload files from nested subfolders under each album folder")
+	fs.Bool("checksum", d.Checksum, "If true (default), compute sha1 checksum and send x-immich-checksum header")
+	fs.String("checksum-cache", d.ChecksumCache, "Path of the persistent checksum cache (default: user cache dir)")
+	fs.Bool("no-checksum-cache", d.NoChecksumCache, "Disable the persistent checksum cache (always re-hash files)")
+	fs.Bool("rebuild-checksum-cache", d.RebuildChecksumCache, "Discard the checksum cache and re-hash every file")
+	fs.Int("batch", d.BatchSize, "How many uploaded assets to add to album per request")
+	fs.Int("workers", d.Workers, "Number of parallel uploads (shared across all albums)")
+	fs.Int("hash-workers", d.HashWorkers, "Number of parallel sha1 hashing workers")
+	fs.Int("preflight-workers", d.PreflightWorkers, "Number of parallel duplicate-check (bulk-upload-check) workers")
+	fs.Bool("no-preflight", d.NoPreflight, "Skip the bulk-upload-check preflight and upload every file")
+	fs.Bool("smallest-first", d.SmallestFirst, "Upload smaller files first")
+	fs.Int("scan-readers", d.ScanReaders, "Number of directories read in parallel while scanning an album folder")
+	fs.Bool("dedupe-add", d.DedupeAdd, "If true, rely on checksum dedupe so existing assets can still be added to the album")
+	fs.Duration("timeout", d.Timeout, "Timeout of each API call; also the shortest deadline of an upload")
+	fs.Duration("connect-timeout", d.ConnectTimeout, "Timeout for connecting to the server (TCP and TLS handshake)")
+	fs.Duration("response-timeout", d.ResponseTimeout, "How long to wait for the server's response once a request has been sent")
+	fs.Duration("stall-timeout", d.StallTimeout, "Abort an upload when no bytes have been sent for this long")
+	fs.String("limit", d.Limit, "Upload bandwidth cap shared by all workers, e.g. 2MiB (per second; empty = unlimited)")
+	fs.String("limit-schedule", d.LimitSchedule, "Time-of-day caps overriding --limit, e.g. 08:00-23:00=1MiB,23:00-08:00=0 (0 = unlimited)")
+	fs.Duration("lock-wait", d.LockWait, "Wait up to this long for another run holding the lock on --root (0 = fail immediately)")
+	fs.Bool("no-lock", d.NoLock, "Do not take the lock on --root (allows concurrent runs on the same root)")
+	fs.String("space-check", d.SpaceCheck, "Count the bytes to upload (duplicates excluded) against the server's free space and quota: abort (upload what fits, then stop)|warn|off")
+	fs.String("ignore-dir", d.IgnoreDir, "Folder name to ignore (and destination for moved folders)")
+	fs.Bool("tui", d.TUI, "Enable single-line TUI status display")
+	fs.Bool("tui-auto", d.TUIAuto, "Auto-enable TUI only when stdout is a terminal (recommended)")
+	fs.String("tui-style", d.TUIStyle, "TUI style: pretty|plain")
+	fs.Bool("no-ansi", d.NoANSI, "Disable ANSI escape sequences (best-effort)")
+	fs.Bool("watch", d.Watch, "Keep running and upload new files as they appear under --root")
+	fs.Duration("settle", d.Settle, "Skip files modified within this quiet period (watch mode: how long a file must stop changing before it is uploaded)")
+	fs.Duration("rescan", d.Rescan, "Watch mode: interval of the full rescan that catches missed notifications")
+	fs.Duration("every", d.Every, "Daemon mode: run every interval, e.g. 1h")
+	fs.String("cron", d.Cron, "Daemon mode: run on a cron schedule, e.g. \"0 3 * * *\" or @daily")
+	fs.Duration("jitter", d.Jitter, "Daemon mode: delay each scheduled run by a random duration up to this")
+	fs.Bool("run-on-start", d.RunOnStart, "Daemon mode: also run once immediately at startup")
+	fs.Int("history", d.History, "Daemon mode: number of run summaries kept in memory (dumped on SIGUSR2)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected argument %q (use --root for the photo folder)\n", fs.Arg(0))
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath, *profile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var setErr error
+	fs.Visit(func(f *flag.Flag) {
+		if setErr != nil || !config.Has(f.Name) {
+			return
+		}
+		setErr = cfg.Set(f.Name, f.Value.String())
+	})
+	if setErr != nil {
+		fmt.Fprintf(os.Stderr, "--%v\n", setErr)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cfg.ResolveAPIKey(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if cfg.APIKey == "" && cfg.SharedLink == "" && cfg.Email != "" {
+		if err := cfg.ResolvePassword(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if cfg.Password == "" && term.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Fprintf(os.Stderr, "Immich password for %s: ", cfg.Email)
+			pw, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+			cfg.Password = string(pw)
+		}
+	}
+	opt, err := cfg.Options()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--%v\n", err)
+		return 2
+	}
+
+	logf := func(format string, args ...any) {
+		fmt.Printf(format, args...)
+	}
+
+	switch {
+	case cmd == "doctor":
+		err = uploader.Doctor(ctx, opt, logf)
+	case cmd == "verify":
+		_, err = uploader.Verify(ctx, opt, logf)
+	case cfg.Every > 0 || cfg.Cron != "":
+		if cfg.Watch {
+			fmt.Fprintln(os.Stderr, "--watch cannot be combined with --every/--cron")
+			return 2
+		}
+		err = serveDaemon(ctx, opt, logf, cfg)
+	case cfg.Watch:
+		err = uploader.Watch(ctx, opt, logf)
+	default:
+		err = uploader.Run(ctx, opt, logf)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// listFlag is a repeatable flag. Its String joins the values with newlines,
+// the list format of config.Set.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, "\n")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// serveDaemon runs uploads on a schedule until ctx is cancelled. SIGUSR1
+// starts a run immediately and SIGUSR2 prints the recent run summaries.
+func serveDaemon(ctx context.Context, opt uploader.Options, logf uploader.Logf, cfg config.Config) error {
+	d := &daemon.Daemon{
+		Run: func(ctx context.Context) (uploader.Summary, error) {
+			return uploader.RunSummary(ctx, opt, logf)
+		},
+		Interval:   cfg.Every,
+		Jitter:     cfg.Jitter,
+		RunOnStart: cfg.RunOnStart,
+		History:    cfg.History,
+		Logf:       logf,
+	}
+	if cfg.Cron != "" {
+		c, err := daemon.ParseCron(cfg.Cron)
+		if err != nil {
+			return err
+		}
+		d.Cron = c
+	}
+	trigger, dump, stop := daemon.NotifySignals()
+	defer stop()
+	d.Trigger, d.Dump = trigger, dump
+	return d.Serve(ctx)
+}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"immich-uploader/immich/immichtest"
+	"immich-uploader/internal/uploader"
+)
+
+// fakeServer runs "fake-server": an in-memory Immich for trying out the
+// uploader (or other clients) offline. State is lost when it exits.
+func fakeServer(args []string) int {
+	fs := flag.NewFlagSet("fake-server", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s fake-server [flags]\n\nServe a fake Immich API (albums, assets, bulk upload check, stacks) from memory.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	var (
+		listen = fs.String("listen", "127.0.0.1:2283", "Address to listen on")
+		key    = fs.String("key", immichtest.DefaultAPIKey, "API key to accept (empty = accept any request)")
+		disk   = fs.String("disk", "1024GiB", "Disk size; uploads that don't fit fail like a full disk")
+		quiet  = fs.Bool("quiet", false, "Do not log requests")
+		faults listFlag
+	)
+	fs.Var(&faults, "fault", "Inject a fault (repeatable): \"[METHOD] PATH ACTION [xN]\", ACTION = status code, drop or latency,\ne.g. \"POST /assets 503 x2\", \"* 200ms\", \"GET /albums drop x1\"")
+	_ = fs.Parse(args)
+
+	s := immichtest.New()
+	s.APIKey = *key
+	size, err := uploader.ParseByteSize(*disk)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--disk: %v\n", err)
+		return 2
+	}
+	s.DiskSize = size
+	for _, f := range faults {
+		fault, err := immichtest.ParseFault(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--%v\n", err)
+			return 2
+		}
+		s.Inject(fault)
+	}
+	if !*quiet {
+		s.Logf = func(format string, args ...any) {
+			fmt.Printf(time.Now().Format("15:04:05 ")+format, args...)
+		}
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Fake Immich %s listening on http://%s/api (API key %q)\n", s.Version, ln.Addr(), s.APIKey)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Handler: s}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
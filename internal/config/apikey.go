@@ -0,0 +1,103 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ResolveAPIKey fills APIKey from KeyFile or KeyCommand when it is not set
+// directly. KeyFile "-" reads standard input. Only the first line of the file
+// or command output is used, so `pass show` style output works unchanged.
+// Nothing is read in shared link mode.
+func (c *Config) ResolveAPIKey(ctx context.Context) error {
+	if c.APIKey != "" || c.SharedLink != "" {
+		return nil
+	}
+	switch {
+	case c.KeyFile != "":
+		key, err := readSecretFile(c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("read API key: %w", err)
+		}
+		c.APIKey = key
+	case c.KeyCommand != "":
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", c.KeyCommand)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", c.KeyCommand)
+		}
+		var stderr bytes.Buffer
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			// stderr may help (e.g. "not logged in"); stdout might be the key
+			return fmt.Errorf("key command: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		key, err := firstLine(bytes.NewReader(out))
+		if err != nil {
+			return fmt.Errorf("key command: %w", err)
+		}
+		c.APIKey = key
+	}
+	return nil
+}
+
+// ResolvePassword fills Password from PasswordFile ("-" = stdin) when it is
+// not set directly.
+func (c *Config) ResolvePassword() error {
+	if c.Password != "" || c.PasswordFile == "" {
+		return nil
+	}
+	pw, err := readSecretFile(c.PasswordFile)
+	if err != nil {
+		return fmt.Errorf("read password: %w", err)
+	}
+	c.Password = pw
+	return nil
+}
+
+// readSecretFile returns the first line of path, or of stdin for "-".
+func readSecretFile(path string) (string, error) {
+	if path == "-" {
+		s, err := firstLine(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("stdin: %w", err)
+		}
+		return s, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s, err := firstLine(f)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+func firstLine(r io.Reader) (string, error) {
+	s := bufio.NewScanner(r)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return "", err
+		}
+		return "", errors.New("empty")
+	}
+	key := strings.TrimSpace(s.Text())
+	if key == "" {
+		return "", errors.New("empty")
+	}
+	return key, nil
+}
@@ -0,0 +1,475 @@
+// Package config loads uploader settings shared by the CLI and the GUI from a
+// TOML file with named profiles and IMMICH_* environment variables.
+//
+// A config file looks like:
+//
+//	# top-level values apply to every profile
+//	immich  = "https://photos.example.com/api"
+//	key     = "..."
+//	profile = "home" # used when no profile is selected
+//
+//	[profiles.home]
+//	root  = "/srv/photos"
+//	limit = "2MiB"
+//
+//	[profiles.nas]
+//	immich = "http://nas:2283/api"
+//	root   = "/mnt/camera"
+//	cron   = "0 3 * * *"
+//
+// Keys are the CLI flag names. Values are resolved with the precedence
+// flags > environment > profile > top-level > defaults; the environment
+// variable of a key is IMMICH_ followed by the key in upper case with dashes
+// replaced by underscores (IMMICH_WORKERS, IMMICH_LIMIT_SCHEDULE), except for
+// immich (IMMICH_URL) and key (IMMICH_API_KEY). List settings (header) are
+// arrays in the file and one value per line in the environment.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"immich-uploader/internal/uploader"
+)
+
+const (
+	// EnvConfig and EnvProfile select the config file and profile when the
+	// corresponding flags are not given.
+	EnvConfig  = "IMMICH_CONFIG"
+	EnvProfile = "IMMICH_PROFILE"
+)
+
+// Config holds every setting that can come from a flag, the environment or
+// the config file. Watch and daemon settings are only used by the CLI.
+type Config struct {
+	BaseURL              string        `toml:"immich" env:"IMMICH_URL"`
+	APIKey               string        `toml:"key" env:"IMMICH_API_KEY"`
+	KeyFile              string        `toml:"key-file"`
+	KeyCommand           string        `toml:"key-command"`
+	Email                string        `toml:"email"`
+	Password             string        `toml:"password"`
+	PasswordFile         string        `toml:"password-file"`
+	SharedLink           string        `toml:"shared-link"`
+	SharedLinkPassword   string        `toml:"shared-link-password"`
+	CACert               string        `toml:"ca-cert"`
+	ClientCert           string        `toml:"client-cert"`
+	ClientKey            string        `toml:"client-key"`
+	Insecure             bool          `toml:"insecure"`
+	Proxy                string        `toml:"proxy"`
+	Header               []string      `toml:"header"`
+	Trace                bool          `toml:"trace"`
+	HAR                  string        `toml:"har"`
+	ValidateOpenAPI      string        `toml:"validate-openapi"`
+	Root                 string        `toml:"root"`
+	Deep                 bool          `toml:"deep"`
+	Checksum             bool          `toml:"checksum"`
+	ChecksumCache        string        `toml:"checksum-cache"`
+	NoChecksumCache      bool          `toml:"no-checksum-cache"`
+	RebuildChecksumCache bool          `toml:"rebuild-checksum-cache"`
+	BatchSize            int           `toml:"batch"`
+	Workers              int           `toml:"workers"`
+	HashWorkers          int           `toml:"hash-workers"`
+	PreflightWorkers     int           `toml:"preflight-workers"`
+	NoPreflight          bool          `toml:"no-preflight"`
+	SmallestFirst        bool          `toml:"smallest-first"`
+	ScanReaders          int           `toml:"scan-readers"`
+	DedupeAdd            bool          `toml:"dedupe-add"`
+	Timeout              time.Duration `toml:"timeout"`
+	ConnectTimeout       time.Duration `toml:"connect-timeout"`
+	ResponseTimeout      time.Duration `toml:"response-timeout"`
+	StallTimeout         time.Duration `toml:"stall-timeout"`
+	Limit                string        `toml:"limit"`
+	LimitSchedule        string        `toml:"limit-schedule"`
+	LockWait             time.Duration `toml:"lock-wait"`
+	NoLock               bool          `toml:"no-lock"`
+	SpaceCheck           string        `toml:"space-check"`
+	IgnoreDir            string        `toml:"ignore-dir"`
+	TUI                  bool          `toml:"tui"`
+	TUIAuto              bool          `toml:"tui-auto"`
+	TUIStyle             string        `toml:"tui-style"`
+	NoANSI               bool          `toml:"no-ansi"`
+	Settle               time.Duration `toml:"settle"`
+
+	Watch      bool          `toml:"watch"`
+	Rescan     time.Duration `toml:"rescan"`
+	Every      time.Duration `toml:"every"`
+	Cron       string        `toml:"cron"`
+	Jitter     time.Duration `toml:"jitter"`
+	RunOnStart bool          `toml:"run-on-start"`
+	History    int           `toml:"history"`
+
+	// Profile is the profile the settings were loaded from (empty = none).
+	Profile string `toml:"-"`
+}
+
+// Defaults returns the built-in settings.
+func Defaults() Config {
+	return Config{
+		BaseURL:          "http://localhost:2283/api",
+		Deep:             true,
+		Checksum:         true,
+		BatchSize:        200,
+		Workers:          4,
+		HashWorkers:      2,
+		PreflightWorkers: 1,
+		SmallestFirst:    true,
+		ScanReaders:      1,
+		DedupeAdd:        true,
+		Timeout:          5 * time.Minute,
+		ConnectTimeout:   30 * time.Second,
+		ResponseTimeout:  2 * time.Minute,
+		StallTimeout:     time.Minute,
+		IgnoreDir:        "ignore",
+		SpaceCheck:       uploader.SpaceCheckAbort,
+		TUIAuto:          true,
+		TUIStyle:         "pretty",
+		Settle:           10 * time.Second,
+		Rescan:           10 * time.Minute,
+		History:          10,
+	}
+}
+
+// DefaultPath is the config file used when neither a path nor IMMICH_CONFIG
+// is given.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "immich-uploader.toml"
+	}
+	return filepath.Join(dir, "immich-uploader", "config.toml")
+}
+
+// file is the on-disk layout: top-level settings plus named profiles.
+type file struct {
+	Config
+	Profile  string                    `toml:"profile"`
+	Profiles map[string]toml.Primitive `toml:"profiles"`
+}
+
+// Load resolves the settings from the defaults, the config file, the selected
+// profile and the environment, in that order. Flags are applied on top by the
+// caller with Set.
+//
+// path and profile may be empty, in which case IMMICH_CONFIG / IMMICH_PROFILE
+// and then DefaultPath / the file's "profile" key are used. A missing file is
+// only an error when its path was given explicitly.
+func Load(path, profile string) (Config, error) {
+	explicit := true
+	if path == "" {
+		path = os.Getenv(EnvConfig)
+	}
+	if path == "" {
+		path, explicit = DefaultPath(), false
+	}
+	if profile == "" {
+		profile = os.Getenv(EnvProfile)
+	}
+
+	f := file{Config: Defaults()}
+	md, err := toml.DecodeFile(path, &f)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && !explicit:
+		if profile != "" {
+			return Config{}, fmt.Errorf("profile %q: no config file at %s", profile, path)
+		}
+	case err != nil:
+		return Config{}, fmt.Errorf("config %s: %w", path, err)
+	default:
+		if profile == "" {
+			profile = f.Profile
+		}
+		// Decode every profile so typos are reported whichever one is used.
+		for name, p := range f.Profiles {
+			dst := &Config{}
+			if name == profile {
+				dst = &f.Config
+			}
+			if err := md.PrimitiveDecode(p, dst); err != nil {
+				return Config{}, fmt.Errorf("config %s: profile %q: %w", path, name, err)
+			}
+		}
+		if keys := md.Undecoded(); len(keys) > 0 {
+			names := make([]string, len(keys))
+			for i, k := range keys {
+				names[i] = k.String()
+			}
+			return Config{}, fmt.Errorf("config %s: unknown keys: %s", path, strings.Join(names, ", "))
+		}
+		if _, ok := f.Profiles[profile]; profile != "" && !ok {
+			return Config{}, fmt.Errorf("config %s: no profile %q", path, profile)
+		}
+	}
+
+	cfg := f.Config
+	cfg.Profile = profile
+	if err := cfg.applyEnv(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// field is one settable Config field.
+type field struct {
+	key   string
+	env   string
+	index int
+}
+
+func fields() []field {
+	t := reflect.TypeOf(Config{})
+	out := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := sf.Tag.Get("toml")
+		if key == "-" {
+			continue
+		}
+		env := sf.Tag.Get("env")
+		if env == "" {
+			env = "IMMICH_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		}
+		out = append(out, field{key: key, env: env, index: i})
+	}
+	return out
+}
+
+func (c *Config) applyEnv() error {
+	for _, f := range fields() {
+		v, ok := os.LookupEnv(f.env)
+		if !ok {
+			continue
+		}
+		if err := setValue(reflect.ValueOf(c).Elem().Field(f.index), v); err != nil {
+			return fmt.Errorf("%s: %w", f.env, err)
+		}
+	}
+	return nil
+}
+
+// Has reports whether key names a setting.
+func Has(key string) bool {
+	for _, f := range fields() {
+		if f.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Set parses value into the setting named key (a config file key / flag name).
+func (c *Config) Set(key, value string) error {
+	for _, f := range fields() {
+		if f.key == key {
+			if err := setValue(reflect.ValueOf(c).Elem().Field(f.index), value); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown setting %q", key)
+}
+
+func setValue(v reflect.Value, s string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(s)
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+	case []string:
+		// one value per line (repeated flags are joined with newlines)
+		var list []string
+		for _, line := range strings.Split(s, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				list = append(list, line)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (c *Config) value(key string) (any, bool) {
+	for _, f := range fields() {
+		if f.key != key {
+			continue
+		}
+		v := reflect.ValueOf(c).Elem().Field(f.index).Interface()
+		switch v := v.(type) {
+		case time.Duration:
+			return v.String(), true
+		case int:
+			return int64(v), true
+		default:
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// Options converts the upload settings to uploader.Options.
+func (c Config) Options() (uploader.Options, error) {
+	rateLimit, err := uploader.ParseByteSize(c.Limit)
+	if err != nil {
+		return uploader.Options{}, fmt.Errorf("limit: %w", err)
+	}
+	headers, err := uploader.ParseHeaders(c.Header)
+	if err != nil {
+		return uploader.Options{}, err
+	}
+	return uploader.Options{
+		BaseURL:              c.BaseURL,
+		APIKey:               c.APIKey,
+		Email:                c.Email,
+		Password:             c.Password,
+		SharedLink:           c.SharedLink,
+		SharedLinkPassword:   c.SharedLinkPassword,
+		CACert:               c.CACert,
+		ClientCert:           c.ClientCert,
+		ClientKey:            c.ClientKey,
+		Insecure:             c.Insecure,
+		Proxy:                c.Proxy,
+		Headers:              headers,
+		Trace:                c.Trace,
+		HARFile:              c.HAR,
+		ValidateOpenAPI:      c.ValidateOpenAPI,
+		Root:                 c.Root,
+		Deep:                 c.Deep,
+		Checksum:             c.Checksum,
+		BatchSize:            c.BatchSize,
+		Workers:              c.Workers,
+		HashWorkers:          c.HashWorkers,
+		PreflightWorkers:     c.PreflightWorkers,
+		NoPreflight:          c.NoPreflight,
+		SmallestFirst:        c.SmallestFirst,
+		ScanReaders:          c.ScanReaders,
+		IgnoreDir:            c.IgnoreDir,
+		Timeout:              c.Timeout,
+		ConnectTimeout:       c.ConnectTimeout,
+		ResponseTimeout:      c.ResponseTimeout,
+		StallTimeout:         c.StallTimeout,
+		RateLimit:            rateLimit,
+		RateSchedule:         c.LimitSchedule,
+		DedupeAdd:            c.DedupeAdd,
+		ChecksumCache:        c.ChecksumCache,
+		NoChecksumCache:      c.NoChecksumCache,
+		RebuildChecksumCache: c.RebuildChecksumCache,
+		LockWait:             c.LockWait,
+		NoLock:               c.NoLock,
+		SpaceCheck:           c.SpaceCheck,
+		TUI:                  c.TUI,
+		TUIAuto:              c.TUIAuto,
+		TUIStyle:             c.TUIStyle,
+		NoANSI:               c.NoANSI,
+		SettleTime:           c.Settle,
+		WatchRescan:          c.Rescan,
+	}, nil
+}
+
+// Save writes the given keys of cfg to the config file at path (DefaultPath
+// if empty), into the named profile or at the top level if profile is empty.
+// Other keys and profiles in the file are kept.
+func Save(path, profile string, cfg Config, keys ...string) error {
+	return edit(path, profile, func(section map[string]any) error {
+		for _, k := range keys {
+			v, ok := cfg.value(k)
+			if !ok {
+				return fmt.Errorf("unknown setting %q", k)
+			}
+			section[k] = v
+		}
+		return nil
+	})
+}
+
+// Remove deletes keys from the config file at path (DefaultPath if empty),
+// from the named profile or the top level if profile is empty, so they fall
+// back to the next source again.
+func Remove(path, profile string, keys ...string) error {
+	return edit(path, profile, func(section map[string]any) error {
+		for _, k := range keys {
+			if !Has(k) {
+				return fmt.Errorf("unknown setting %q", k)
+			}
+			delete(section, k)
+		}
+		return nil
+	})
+}
+
+// edit applies fn to the top level or a profile of the config file and
+// writes it back atomically.
+func edit(path, profile string, fn func(section map[string]any) error) error {
+	if path == "" {
+		path = os.Getenv(EnvConfig)
+	}
+	if path == "" {
+		path = DefaultPath()
+	}
+	doc := map[string]any{}
+	if _, err := toml.DecodeFile(path, &doc); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+
+	section := doc
+	if profile != "" {
+		profiles, _ := doc["profiles"].(map[string]any)
+		if profiles == nil {
+			profiles = map[string]any{}
+			doc["profiles"] = profiles
+		}
+		section, _ = profiles[profile].(map[string]any)
+		if section == nil {
+			section = map[string]any{}
+			profiles[profile] = section
+		}
+	}
+	if err := fn(section); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	enc := toml.NewEncoder(f)
+	enc.Indent = ""
+	err = enc.Encode(doc)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveAndRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	t.Setenv(EnvConfig, "")
+	t.Setenv(EnvProfile, "")
+	t.Setenv("IMMICH_API_KEY", "") // restored after the test
+	os.Unsetenv("IMMICH_API_KEY")
+
+	cfg := Defaults()
+	cfg.APIKey, cfg.Root, cfg.Workers = "secret", "/photos", 8
+	if err := Save(path, "", cfg, "key", "root"); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Root = "/camera"
+	if err := Save(path, "camera", cfg, "root", "workers"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load(path, "camera")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.APIKey != "secret" || got.Root != "/camera" || got.Workers != 8 {
+		t.Errorf("loaded %q %q %d, want the top-level key and the profile's root and workers", got.APIKey, got.Root, got.Workers)
+	}
+
+	if err := Remove(path, "", "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Remove(path, "camera", "workers"); err != nil {
+		t.Fatal(err)
+	}
+	got, err = Load(path, "camera")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.APIKey != "" || got.Root != "/camera" || got.Workers != Defaults().Workers {
+		t.Errorf("after Remove: %q %q %d, want no key, the profile's root and the default workers", got.APIKey, got.Root, got.Workers)
+	}
+	if err := Remove(path, "", "nope"); err == nil {
+		t.Error("Remove of an unknown key succeeded")
+	}
+}
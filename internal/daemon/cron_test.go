@@ -0,0 +1,138 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // Europe/Berlin for the DST cases
+)
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{
+		"* * * * *",
+		"*/15 9-17 * * 1-5",
+		"0,30 0 1,15 * *",
+		"0-30/5 * * 1-12/3 *",
+		"0 0 * * 7",
+		"@hourly", "@daily", "@midnight", "@weekly", "@monthly", "@yearly", "@annually",
+		"  0 0 * * *  ",
+	} {
+		if _, err := ParseCron(spec); err != nil {
+			t.Errorf("ParseCron(%q): %v", spec, err)
+		}
+	}
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"@every 5m",
+	} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q): no error", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	for _, tc := range []struct {
+		spec, from, want string
+	}{
+		{"* * * * *", "2026-01-01 10:00", "2026-01-01 10:01"},
+		{"*/15 * * * *", "2026-01-01 10:14", "2026-01-01 10:15"},
+		{"*/15 * * * *", "2026-01-01 10:15", "2026-01-01 10:30"},
+		{"0-30/10 * * * *", "2026-01-01 10:31", "2026-01-01 11:00"},
+		{"5 * * * *", "2026-01-01 23:59", "2026-01-02 00:05"},
+		{"0,30 9-17 * * *", "2026-01-01 17:30", "2026-01-02 09:00"},
+		{"0 */6 * * *", "2026-01-01 13:00", "2026-01-01 18:00"},
+		{"@daily", "2026-01-31 12:00", "2026-02-01 00:00"},
+		{"@monthly", "2026-12-15 00:00", "2027-01-01 00:00"},
+		{"@yearly", "2026-06-01 00:00", "2027-01-01 00:00"},
+		// 2026-01-01 is a Thursday
+		{"0 8 * * 1-5", "2026-01-02 09:00", "2026-01-05 08:00"},
+		{"0 0 * * 0", "2026-01-01 00:00", "2026-01-04 00:00"},
+		{"0 0 * * 7", "2026-01-01 00:00", "2026-01-04 00:00"},
+		{"@weekly", "2026-01-04 00:00", "2026-01-11 00:00"},
+		// day-of-month or day-of-week when both are restricted
+		{"0 0 13 * 5", "2026-01-01 00:00", "2026-01-02 00:00"},
+		{"0 0 13 * 5", "2026-01-10 00:00", "2026-01-13 00:00"},
+		// both, when one of them is *
+		{"0 0 13 * *", "2026-01-13 00:00", "2026-02-13 00:00"},
+		{"0 0 */2 * 1", "2026-01-01 00:00", "2026-01-05 00:00"},
+		// month rollover and short months
+		{"0 0 31 * *", "2026-01-31 00:00", "2026-03-31 00:00"},
+		{"0 0 29 2 *", "2026-01-01 00:00", "2028-02-29 00:00"},
+		{"0 0 1 3,9 *", "2026-03-01 00:00", "2026-09-01 00:00"},
+		// seconds are dropped
+		{"* * * * *", "2026-01-01 10:00", "2026-01-01 10:01"},
+	} {
+		c, err := ParseCron(tc.spec)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tc.spec, err)
+		}
+		if got := c.Next(at(tc.from)); !got.Equal(at(tc.want)) {
+			t.Errorf("%q after %s: %s, want %s", tc.spec, tc.from, got.Format("2006-01-02 15:04"), tc.want)
+		}
+	}
+
+	never, _ := ParseCron("0 0 30 2 *")
+	if got := never.Next(at("2026-01-01 00:00")); !got.IsZero() {
+		t.Errorf("0 0 30 2 *: %s, want never", got)
+	}
+	every, _ := ParseCron("* * * * *")
+	if got := every.Next(at("2026-01-01 10:00").Add(30 * time.Second)); !got.Equal(at("2026-01-01 10:01")) {
+		t.Errorf("* * * * * after 10:00:30: %s, want 10:01", got)
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The clocks go from 02:00 CET to 03:00 CEST on 2026-03-29 and from
+	// 03:00 CEST back to 02:00 CET on 2026-10-25.
+	cet, cest := time.FixedZone("CET", 3600), time.FixedZone("CEST", 7200)
+	for _, tc := range []struct {
+		spec       string
+		from, want time.Time
+	}{
+		// 02:30 doesn't exist on the day the clocks go forward
+		{"30 2 * * *", time.Date(2026, 3, 29, 0, 0, 0, 0, cet), time.Date(2026, 3, 30, 2, 30, 0, 0, cest)},
+		{"0 3 * * *", time.Date(2026, 3, 29, 0, 0, 0, 0, cet), time.Date(2026, 3, 29, 3, 0, 0, 0, cest)},
+		{"0 * * * *", time.Date(2026, 3, 29, 1, 30, 0, 0, cet), time.Date(2026, 3, 29, 3, 0, 0, 0, cest)},
+		// 02:30 happens twice on the day they go back, and fires once
+		{"30 2 * * *", time.Date(2026, 10, 25, 0, 0, 0, 0, cest), time.Date(2026, 10, 25, 2, 30, 0, 0, cest)},
+		{"30 2 * * *", time.Date(2026, 10, 25, 2, 30, 0, 0, cest), time.Date(2026, 10, 26, 2, 30, 0, 0, cet)},
+		// unless started during the second one
+		{"30 2 * * *", time.Date(2026, 10, 25, 2, 10, 0, 0, cet), time.Date(2026, 10, 25, 2, 30, 0, 0, cet)},
+		// hourly runs go on through the repeated hour
+		{"0 * * * *", time.Date(2026, 10, 25, 2, 0, 0, 0, cest), time.Date(2026, 10, 25, 2, 0, 0, 0, cet)},
+		{"0 * * * *", time.Date(2026, 10, 25, 2, 0, 0, 0, cet), time.Date(2026, 10, 25, 3, 0, 0, 0, cet)},
+	} {
+		c, err := ParseCron(tc.spec)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tc.spec, err)
+		}
+		if got := c.Next(tc.from.In(berlin)); !got.Equal(tc.want) {
+			t.Errorf("%q after %s: %s, want %s", tc.spec, tc.from, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Package daemon runs the uploader unattended on a fixed interval or a cron
+// schedule.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"immich-uploader/internal/uploader"
+)
+
+// RunFunc performs one upload run.
+type RunFunc func(ctx context.Context) (uploader.Summary, error)
+
+// Record is one finished run kept in the daemon's history.
+type Record struct {
+	Started time.Time
+	Trigger string // "schedule", "manual" or "startup"
+	Summary uploader.Summary
+	Err     error
+}
+
+func (r Record) String() string {
+	status := "ok"
+	if r.Err != nil {
+		status = "error: " + r.Err.Error()
+	}
+	return fmt.Sprintf("%s (%s) %s | %s", r.Started.Format(time.DateTime), r.Trigger, r.Summary, status)
+}
+
+// Daemon executes Run on Interval or Cron (exactly one must be set), never
+// running two at once. Each scheduled run is delayed by a random duration in
+// [0, Jitter); the schedule itself stays anchored to the undelayed times. A value on Trigger starts a run immediately, and a value on
+// Dump logs the history of the last History runs (default 10).
+type Daemon struct {
+	Run        RunFunc
+	Interval   time.Duration
+	Cron       *Cron
+	Jitter     time.Duration
+	RunOnStart bool
+	History    int
+	Trigger    <-chan struct{}
+	Dump       <-chan struct{}
+	Logf       uploader.Logf
+
+	mu      sync.Mutex
+	running bool
+	records []Record
+}
+
+// Serve blocks until ctx is cancelled, then waits for an in-progress run.
+func (d *Daemon) Serve(ctx context.Context) error {
+	if (d.Interval <= 0) == (d.Cron == nil) {
+		return errors.New("daemon: set exactly one of an interval or a cron schedule")
+	}
+	if d.Logf == nil {
+		d.Logf = func(string, ...any) {}
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	start := func(trigger string) {
+		d.mu.Lock()
+		if d.running {
+			d.mu.Unlock()
+			d.Logf("Daemon: previous run still in progress, skipping %s run\n", trigger)
+			return
+		}
+		d.running = true
+		d.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Logf("Daemon: starting %s run\n", trigger)
+			started := time.Now()
+			sum, err := d.Run(ctx)
+			rec := Record{Started: started, Trigger: trigger, Summary: sum, Err: err}
+			d.Logf("Daemon: run finished: %s\n", rec)
+
+			d.mu.Lock()
+			d.running = false
+			d.records = append(d.records, rec)
+			if n := d.history(); len(d.records) > n {
+				d.records = append([]Record(nil), d.records[len(d.records)-n:]...)
+			}
+			d.mu.Unlock()
+		}()
+	}
+
+	if d.RunOnStart {
+		start("startup")
+	}
+
+	sched, next := d.next(time.Time{}, time.Now())
+	for {
+		if next.IsZero() {
+			return errors.New("daemon: cron schedule never fires")
+		}
+		d.Logf("Daemon: next run at %s\n", next.Format(time.DateTime))
+
+		// Triggers and dumps don't move the schedule.
+		waiting := true
+		for waiting {
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-d.Trigger:
+				timer.Stop()
+				start("manual")
+			case <-d.Dump:
+				timer.Stop()
+				d.dump()
+			case <-timer.C:
+				start("schedule")
+				waiting = false
+			}
+		}
+		sched, next = d.next(sched, time.Now())
+	}
+}
+
+// next returns the scheduled time that follows prev (now for the first run)
+// and when to start that run, jitter included. Times already past when a run
+// ends up late are skipped rather than caught up on. sched is zero if the
+// cron schedule never fires.
+func (d *Daemon) next(prev, now time.Time) (sched, start time.Time) {
+	if prev.IsZero() {
+		prev = now
+	}
+	if d.Cron != nil {
+		sched = d.Cron.Next(prev)
+		if !sched.IsZero() && !sched.After(now) {
+			sched = d.Cron.Next(now)
+		}
+		if sched.IsZero() {
+			return sched, sched
+		}
+	} else {
+		sched = prev.Add(d.Interval)
+		if late := now.Sub(sched); late >= 0 {
+			sched = sched.Add((late/d.Interval + 1) * d.Interval)
+		}
+	}
+	start = sched
+	if d.Jitter > 0 {
+		start = start.Add(time.Duration(rand.Int63n(int64(d.Jitter))))
+	}
+	return sched, start
+}
+
+func (d *Daemon) history() int {
+	if d.History <= 0 {
+		return 10
+	}
+	return d.History
+}
+
+// Records returns the most recent runs, oldest first.
+func (d *Daemon) Records() []Record {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Record(nil), d.records...)
+}
+
+func (d *Daemon) dump() {
+	recs := d.Records()
+	d.Logf("Daemon: last %d runs:\n", len(recs))
+	for _, r := range recs {
+		d.Logf("  %s\n", r)
+	}
+}
@@ -0,0 +1,164 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"immich-uploader/internal/uploader"
+)
+
+func TestNext(t *testing.T) {
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	hourly, _ := ParseCron("0 * * * *")
+	for _, tc := range []struct {
+		name      string
+		interval  time.Duration
+		cron      *Cron
+		prev, now time.Time
+		want      time.Time
+	}{
+		{"first interval", time.Hour, nil, time.Time{}, base, base.Add(time.Hour)},
+		// the schedule doesn't drift by the jitter the previous run started with
+		{"interval", time.Hour, nil, base, base.Add(5 * time.Minute), base.Add(time.Hour)},
+		{"missed intervals", time.Hour, nil, base, base.Add(150 * time.Minute), base.Add(3 * time.Hour)},
+		{"interval due now", time.Hour, nil, base, base.Add(time.Hour), base.Add(2 * time.Hour)},
+		{"first cron", 0, hourly, time.Time{}, base.Add(10 * time.Minute), base.Add(time.Hour)},
+		{"cron", 0, hourly, base, base.Add(5 * time.Minute), base.Add(time.Hour)},
+		{"missed cron", 0, hourly, base, base.Add(150 * time.Minute), base.Add(3 * time.Hour)},
+	} {
+		for _, jitter := range []time.Duration{0, 10 * time.Minute} {
+			d := &Daemon{Interval: tc.interval, Cron: tc.cron, Jitter: jitter}
+			for range 20 {
+				sched, start := d.next(tc.prev, tc.now)
+				if !sched.Equal(tc.want) {
+					t.Fatalf("%s, jitter %s: scheduled at %s, want %s", tc.name, jitter, sched, tc.want)
+				}
+				if delay := start.Sub(sched); delay < 0 || delay > jitter || (delay == jitter && jitter > 0) {
+					t.Fatalf("%s, jitter %s: starts at %s, want in [%s, +%s)", tc.name, jitter, start, sched, jitter)
+				}
+			}
+		}
+	}
+
+	never, _ := ParseCron("0 0 30 2 *")
+	if sched, start := (&Daemon{Cron: never}).next(time.Time{}, base); !sched.IsZero() || !start.IsZero() {
+		t.Errorf("cron that never fires: %s, %s", sched, start)
+	}
+}
+
+// serve runs d until the returned stop is called, logging into log.
+func serve(t *testing.T, d *Daemon) (log func() string, stop func()) {
+	t.Helper()
+	var mu sync.Mutex
+	var b strings.Builder
+	d.Logf = func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(&b, format, args...)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Serve(ctx) }()
+	log = func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return b.String()
+	}
+	stop = func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	}
+	return log, stop
+}
+
+func waitFor(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !ok(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestNoOverlappingRuns(t *testing.T) {
+	release := make(chan struct{})
+	trigger := make(chan struct{})
+	var mu sync.Mutex
+	running, most := 0, 0
+	d := &Daemon{
+		Interval:   time.Hour,
+		RunOnStart: true,
+		Trigger:    trigger,
+		Run: func(ctx context.Context) (uploader.Summary, error) {
+			mu.Lock()
+			running++
+			most = max(most, running)
+			mu.Unlock()
+			<-release
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return uploader.Summary{}, nil
+		},
+	}
+	log, stop := serve(t, d)
+	waitFor(t, "the startup run", func() bool { return strings.Contains(log(), "starting startup run") })
+	trigger <- struct{}{}
+	waitFor(t, "the manual run to be skipped", func() bool { return strings.Contains(log(), "still in progress, skipping manual run") })
+	close(release)
+	waitFor(t, "the startup run to finish", func() bool { return len(d.Records()) == 1 })
+
+	// Once it has finished, the next trigger starts a run.
+	trigger <- struct{}{}
+	waitFor(t, "the manual run", func() bool { return len(d.Records()) == 2 })
+	stop()
+	if most != 1 {
+		t.Errorf("%d runs at once", most)
+	}
+	if got := d.Records()[1].Trigger; got != "manual" {
+		t.Errorf("second run triggered by %q, want manual", got)
+	}
+}
+
+func TestHistoryTrimmed(t *testing.T) {
+	trigger := make(chan struct{})
+	dump := make(chan struct{})
+	runs := 0
+	d := &Daemon{
+		Interval: time.Hour,
+		History:  3,
+		Trigger:  trigger,
+		Dump:     dump,
+		Run: func(ctx context.Context) (uploader.Summary, error) {
+			runs++ // runs never overlap
+			return uploader.Summary{Files: runs}, nil
+		},
+	}
+	log, stop := serve(t, d)
+	for i := 1; i <= 5; i++ {
+		trigger <- struct{}{}
+		waitFor(t, fmt.Sprintf("run %d", i), func() bool {
+			recs := d.Records()
+			return len(recs) > 0 && recs[len(recs)-1].Summary.Files == i
+		})
+	}
+	dump <- struct{}{}
+	waitFor(t, "the dump", func() bool { return strings.Contains(log(), "last 3 runs") })
+	stop()
+
+	recs := d.Records()
+	if len(recs) != 3 {
+		t.Fatalf("%d records, want 3", len(recs))
+	}
+	for i, r := range recs {
+		if r.Summary.Files != i+3 {
+			t.Errorf("record %d is run %d, want run %d", i, r.Summary.Files, i+3)
+		}
+	}
+}
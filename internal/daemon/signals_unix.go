@@ -0,0 +1,40 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// NotifySignals returns channels for the Trigger (SIGUSR1) and Dump (SIGUSR2)
+// fields, and a function that stops the notifications.
+func NotifySignals() (trigger, dump <-chan struct{}, stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	t := make(chan struct{}, 1)
+	d := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case s := <-sigs:
+				ch := t
+				if s == syscall.SIGUSR2 {
+					ch = d
+				}
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return t, d, func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package daemon
+
+// NotifySignals returns nil channels on Windows, which has no SIGUSR1/SIGUSR2.
+func NotifySignals() (trigger, dump <-chan struct{}, stop func()) {
+	return nil, nil, func() {}
+}
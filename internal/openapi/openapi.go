@@ -0,0 +1,546 @@
+// Package openapi checks Immich API traffic against the OpenAPI spec bundled
+// with the repo (immich-openapi.json, the release in Spec.Version).
+//
+// Only the schema keywords the spec uses are understood: $ref, type,
+// nullable, required, properties, additionalProperties, items, enum,
+// allOf/anyOf/oneOf, format (uuid, date-time, date), pattern and the numeric
+// and array bounds. Mismatches are returned as "$.path: problem" strings,
+// with array indexes collapsed to [] so that one drifted field in a list of
+// thousands is reported once.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed immich-openapi.json
+var specJSON []byte
+
+// Spec is a parsed OpenAPI document.
+type Spec struct {
+	Version string
+
+	paths   []pathItem
+	schemas map[string]*Schema
+}
+
+type pathItem struct {
+	path string
+	segs []string
+	ops  map[string]*Operation // by upper-case method
+}
+
+// Operation is one method on one path of the spec.
+type Operation struct {
+	Method string
+	Path   string // as in the spec, e.g. /albums/{id}/assets
+
+	spec        *Spec
+	requestBody *requestBody
+	responses   map[string]response // by status code or "default"
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Content map[string]mediaType `json:"content"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of an OpenAPI 3.0 schema object that is validated.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Nullable             bool               `json:"nullable"`
+	Required             []string           `json:"required"`
+	Properties           map[string]*Schema `json:"properties"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []any              `json:"enum"`
+	AllOf                []*Schema          `json:"allOf"`
+	AnyOf                []*Schema          `json:"anyOf"`
+	OneOf                []*Schema          `json:"oneOf"`
+	Pattern              string             `json:"pattern"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+
+	once    sync.Once
+	pattern *regexp.Regexp
+	extra   *Schema // decoded AdditionalProperties, if it is a schema
+}
+
+var load = sync.OnceValues(func() (*Spec, error) { return Parse(specJSON) })
+
+// Load returns the bundled spec, parsed once.
+func Load() (*Spec, error) {
+	return load()
+}
+
+// Parse reads an OpenAPI 3.0 document.
+func Parse(b []byte) (*Spec, error) {
+	var doc struct {
+		Info struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]*Schema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	s := &Spec{Version: doc.Info.Version, schemas: doc.Components.Schemas}
+	for path, methods := range doc.Paths {
+		item := pathItem{path: path, segs: splitPath(path), ops: map[string]*Operation{}}
+		for method, raw := range methods {
+			var op struct {
+				RequestBody *requestBody        `json:"requestBody"`
+				Responses   map[string]response `json:"responses"`
+			}
+			if json.Unmarshal(raw, &op) != nil {
+				continue // "parameters" and other non-operation members
+			}
+			m := strings.ToUpper(method)
+			item.ops[m] = &Operation{Method: m, Path: path, spec: s, requestBody: op.RequestBody, responses: op.Responses}
+		}
+		s.paths = append(s.paths, item)
+	}
+	return s, nil
+}
+
+func splitPath(p string) []string {
+	return strings.Split(strings.Trim(p, "/"), "/")
+}
+
+// Find returns the operation for a request path relative to the API root
+// (/albums/{id}/assets), or nil if the spec has none. Of several matches the
+// one with the most literal segments wins, so /assets/bulk-upload-check
+// beats /assets/{id}.
+func (s *Spec) Find(method, urlPath string) *Operation {
+	segs := splitPath(urlPath)
+	var (
+		best     *Operation
+		bestLits = -1
+	)
+	for _, item := range s.paths {
+		op := item.ops[strings.ToUpper(method)]
+		if op == nil || len(item.segs) != len(segs) {
+			continue
+		}
+		lits := 0
+		for i, seg := range item.segs {
+			if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+				continue
+			}
+			if seg != segs[i] {
+				lits = -1
+				break
+			}
+			lits++
+		}
+		if lits > bestLits {
+			best, bestLits = op, lits
+		}
+	}
+	return best
+}
+
+// isJSON reports whether a content type is JSON.
+func isJSON(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+func jsonSchema(content map[string]mediaType) (*Schema, bool) {
+	for ct, m := range content {
+		if isJSON(ct) {
+			return m.Schema, true
+		}
+	}
+	return nil, false
+}
+
+// CheckRequest checks a request body; contentType is empty for requests
+// without one.
+func (op *Operation) CheckRequest(contentType string, body []byte) []string {
+	if op.requestBody == nil {
+		if len(body) > 0 {
+			return []string{"the operation takes no request body"}
+		}
+		return nil
+	}
+	if len(body) == 0 {
+		if op.requestBody.Required {
+			return []string{"missing required request body"}
+		}
+		return nil
+	}
+	if !isJSON(contentType) {
+		return nil // multipart bodies go through CheckForm
+	}
+	schema, ok := jsonSchema(op.requestBody.Content)
+	if !ok {
+		return []string{fmt.Sprintf("content type %s, expected one of %s", contentType, mediaTypes(op.requestBody.Content))}
+	}
+	return op.spec.check(schema, body, true)
+}
+
+// FormField is one part of a multipart request. File parts have no Value.
+type FormField struct {
+	Name  string
+	Value string
+	File  bool
+}
+
+// CheckForm checks the fields of a multipart request.
+func (op *Operation) CheckForm(fields []FormField) []string {
+	if op.requestBody == nil {
+		return []string{"the operation takes no request body"}
+	}
+	var schema *Schema
+	for ct, m := range op.requestBody.Content {
+		if strings.HasPrefix(ct, "multipart/") {
+			schema = m.Schema
+		}
+	}
+	if schema == nil {
+		return []string{fmt.Sprintf("multipart body, expected one of %s", mediaTypes(op.requestBody.Content))}
+	}
+	schema = op.spec.resolve(schema)
+
+	var out []string
+	seen := map[string]bool{}
+	for _, f := range fields {
+		seen[f.Name] = true
+		prop := schema.Properties[f.Name]
+		if prop == nil {
+			out = append(out, fmt.Sprintf("$.%s: unknown form field", f.Name))
+			continue
+		}
+		prop = op.spec.resolve(prop)
+		if prop.Format == "binary" {
+			if !f.File {
+				out = append(out, fmt.Sprintf("$.%s: expected a file part", f.Name))
+			}
+			continue
+		}
+		if f.File {
+			out = append(out, fmt.Sprintf("$.%s: unexpected file part", f.Name))
+			continue
+		}
+		// Form values are strings; convert them as the server does.
+		var v any = f.Value
+		switch prop.Type {
+		case "boolean":
+			b, err := strconv.ParseBool(f.Value)
+			if err != nil {
+				out = append(out, fmt.Sprintf("$.%s: %q is not a boolean", f.Name, f.Value))
+				continue
+			}
+			v = b
+		case "integer", "number":
+			v = json.Number(f.Value)
+		}
+		out = append(out, op.spec.validate(prop, v, "$."+f.Name, true)...)
+	}
+	for _, name := range schema.Required {
+		if !seen[name] {
+			out = append(out, fmt.Sprintf("$.%s: missing required form field", name))
+		}
+	}
+	return out
+}
+
+// CheckResponse checks a successful response. Error responses are not
+// checked: the spec doesn't describe them.
+func (op *Operation) CheckResponse(status int, contentType string, body []byte) []string {
+	if status < 200 || status >= 300 {
+		return nil
+	}
+	r, ok := op.responses[strconv.Itoa(status)]
+	if !ok {
+		if r, ok = op.responses["default"]; !ok {
+			return []string{fmt.Sprintf("undocumented status %d (documented: %s)", status, op.statuses())}
+		}
+	}
+	if len(r.Content) == 0 {
+		return nil
+	}
+	schema, ok := jsonSchema(r.Content)
+	if !ok {
+		return nil // binary downloads
+	}
+	if !isJSON(contentType) {
+		return []string{fmt.Sprintf("content type %q, expected application/json", contentType)}
+	}
+	if len(body) == 0 {
+		return []string{"empty response body"}
+	}
+	return op.spec.check(schema, body, false)
+}
+
+func (op *Operation) statuses() string {
+	var codes []string
+	for code := range op.responses {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	return strings.Join(codes, ", ")
+}
+
+func mediaTypes(content map[string]mediaType) string {
+	var types []string
+	for ct := range content {
+		types = append(types, ct)
+	}
+	slices.Sort(types)
+	return strings.Join(types, ", ")
+}
+
+func (s *Spec) check(schema *Schema, body []byte, request bool) []string {
+	dec := json.NewDecoder(strings.NewReader(string(body)))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return []string{fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	return dedupe(s.validate(schema, v, "$", request))
+}
+
+// resolve follows $ref to a component schema.
+func (s *Spec) resolve(schema *Schema) *Schema {
+	for schema != nil && schema.Ref != "" {
+		name, ok := strings.CutPrefix(schema.Ref, "#/components/schemas/")
+		if !ok {
+			return &Schema{} // external references are not followed
+		}
+		schema = s.schemas[name]
+	}
+	if schema == nil {
+		return &Schema{}
+	}
+	return schema
+}
+
+// validate checks v against schema. Properties the spec doesn't know are
+// mismatches in requests (our DTOs drifted) but not in responses (newer
+// servers add fields).
+func (s *Spec) validate(schema *Schema, v any, path string, request bool) []string {
+	schema = s.resolve(schema)
+	if v == nil {
+		if schema.Nullable || schema.Type == "" && len(schema.AllOf) == 0 {
+			return nil
+		}
+		return []string{path + ": null, expected " + schema.describe()}
+	}
+
+	var out []string
+	for _, sub := range schema.AllOf {
+		out = append(out, s.validate(sub, v, path, request)...)
+	}
+	if alts := slices.Concat(schema.AnyOf, schema.OneOf); len(alts) > 0 {
+		matched := false
+		for _, sub := range alts {
+			if len(s.validate(sub, v, path, request)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			out = append(out, path+": matches none of the allowed schemas")
+		}
+	}
+
+	switch schema.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return append(out, fmt.Sprintf("%s: %s, expected object", path, kind(v)))
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				out = append(out, fmt.Sprintf("%s.%s: missing required property", path, name))
+			}
+		}
+		extra := schema.additional()
+		for name, val := range obj {
+			switch prop := schema.Properties[name]; {
+			case prop != nil:
+				out = append(out, s.validate(prop, val, path+"."+name, request)...)
+			case extra != nil:
+				out = append(out, s.validate(extra, val, path+"."+name, request)...)
+			case request && len(schema.Properties) > 0:
+				out = append(out, fmt.Sprintf("%s.%s: unknown property", path, name))
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return append(out, fmt.Sprintf("%s: %s, expected array", path, kind(v)))
+		}
+		if schema.MinItems != nil && len(arr) < *schema.MinItems {
+			out = append(out, fmt.Sprintf("%s: %d items, expected at least %d", path, len(arr), *schema.MinItems))
+		}
+		if schema.MaxItems != nil && len(arr) > *schema.MaxItems {
+			out = append(out, fmt.Sprintf("%s: %d items, expected at most %d", path, len(arr), *schema.MaxItems))
+		}
+		if schema.Items != nil {
+			for _, item := range arr {
+				out = append(out, s.validate(schema.Items, item, path+"[]", request)...)
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return append(out, fmt.Sprintf("%s: %s, expected string", path, kind(v)))
+		}
+		if msg := schema.checkString(str); msg != "" {
+			out = append(out, path+": "+msg)
+		}
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return append(out, fmt.Sprintf("%s: %s, expected %s", path, kind(v), schema.Type))
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return append(out, fmt.Sprintf("%s: %q is not a number", path, n))
+		}
+		if schema.Type == "integer" {
+			if _, err := n.Int64(); err != nil {
+				out = append(out, fmt.Sprintf("%s: %s is not an integer", path, n))
+			}
+		}
+		if schema.Minimum != nil && f < *schema.Minimum {
+			out = append(out, fmt.Sprintf("%s: %s is below the minimum %v", path, n, *schema.Minimum))
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			out = append(out, fmt.Sprintf("%s: %s is above the maximum %v", path, n, *schema.Maximum))
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			out = append(out, fmt.Sprintf("%s: %s, expected boolean", path, kind(v)))
+		}
+	}
+	if len(schema.Enum) > 0 && !schema.allows(v) {
+		out = append(out, fmt.Sprintf("%s: %v is not one of %v", path, v, schema.Enum))
+	}
+	return out
+}
+
+var uuidRE = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func (schema *Schema) checkString(str string) string {
+	switch schema.Format {
+	case "uuid":
+		if !uuidRE.MatchString(str) {
+			return fmt.Sprintf("%q is not a UUID", str)
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+			return fmt.Sprintf("%q is not an RFC 3339 date-time", str)
+		}
+	case "date":
+		if _, err := time.Parse(time.DateOnly, str); err != nil {
+			return fmt.Sprintf("%q is not a date", str)
+		}
+	}
+	schema.once.Do(schema.compile)
+	if schema.pattern != nil && !schema.pattern.MatchString(str) {
+		return fmt.Sprintf("%q does not match %s", str, schema.Pattern)
+	}
+	return ""
+}
+
+// compile prepares the pattern (written /like this/ in places) and the
+// additionalProperties schema.
+func (schema *Schema) compile() {
+	if schema.Pattern != "" {
+		p := schema.Pattern
+		if len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			p = p[1 : len(p)-1]
+		}
+		schema.pattern, _ = regexp.Compile(p) // an invalid pattern is not checked
+	}
+	if len(schema.AdditionalProperties) > 0 && schema.AdditionalProperties[0] == '{' {
+		var extra Schema
+		if json.Unmarshal(schema.AdditionalProperties, &extra) == nil {
+			schema.extra = &extra
+		}
+	}
+}
+
+// additional is the schema of properties not listed, or nil.
+func (schema *Schema) additional() *Schema {
+	schema.once.Do(schema.compile)
+	return schema.extra
+}
+
+func (schema *Schema) allows(v any) bool {
+	for _, e := range schema.Enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (schema *Schema) describe() string {
+	switch {
+	case schema.Type != "":
+		return schema.Type
+	case schema.Ref != "":
+		return schema.Ref
+	}
+	return "a value"
+}
+
+func kind(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func dedupe(msgs []string) []string {
+	seen := map[string]bool{}
+	out := msgs[:0]
+	for _, m := range msgs {
+		if !seen[m] {
+			seen[m] = true
+			out = append(out, m)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
@@ -0,0 +1,30 @@
+package uploader
+
+import (
+	"context"
+	"time"
+
+	"immich-uploader/immich"
+)
+
+// Password auth (for users without an API key) is an immich.Session: the
+// client logs in at the start of the run, logs in again when the server
+// rejects the access token, and logs out at the end.
+
+const logoutTimeout = 10 * time.Second
+
+// login starts the password session and validates the new token.
+func (c *client) login(ctx context.Context) (immich.LoginResponse, error) {
+	return c.Login(ctx)
+}
+
+// logout ends the session. It uses its own timeout so it still runs after
+// the run's context was cancelled.
+func (c *client) logout() error {
+	if _, ok := c.Auth.(*immich.Session); !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), logoutTimeout)
+	defer cancel()
+	return c.Logout(ctx)
+}
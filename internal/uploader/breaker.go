@@ -0,0 +1,133 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// breakerThreshold consecutive server or network failures pause the run.
+	breakerThreshold = 5
+	// While paused, /server/ping is polled with exponential backoff.
+	breakerMinBackoff = 5 * time.Second
+	breakerMaxBackoff = 5 * time.Minute
+	// breakerRetries bounds how often one file is retried after a pause.
+	breakerRetries = 3
+)
+
+// skipAborted is the skip reason of files that were never uploaded because
+// the run was stopped (credentials rejected, or cancelled).
+const skipAborted = "run aborted"
+
+// breaker is the run-wide circuit breaker. An authentication failure, or a
+// permission failure of a request every file depends on, cancels the run,
+// since every other request would fail the same way. A streak of server or
+// network failures pauses all requests until the server answers /server/ping
+// again.
+type breaker struct {
+	c      *client
+	cancel context.CancelCauseFunc
+	logf   Logf
+
+	abort    sync.Once
+	mu       sync.Mutex
+	failures int           // consecutive transient failures
+	paused   chan struct{} // closed when the server is back; nil = not paused
+}
+
+func newBreaker(c *client, cancel context.CancelCauseFunc, logf Logf) *breaker {
+	return &breaker{c: c, cancel: cancel, logf: logf}
+}
+
+// wait blocks while the breaker is paused. It returns the reason the run was
+// stopped, if it was.
+func (b *breaker) wait(ctx context.Context) error {
+	b.mu.Lock()
+	paused := b.paused
+	b.mu.Unlock()
+	if paused != nil {
+		select {
+		case <-paused:
+		case <-ctx.Done():
+		}
+	}
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return nil
+}
+
+// observe records the outcome of a request every file depends on (album
+// creation, duplicate checks) and reports whether it should be retried once
+// the server is back.
+func (b *breaker) observe(ctx context.Context, err error) bool {
+	return b.record(ctx, err, true)
+}
+
+// observeOne is observe for a request about a single asset or album. A 403
+// there (an album restriction, a file type rejected by policy) fails only
+// that item instead of stopping the run.
+func (b *breaker) observeOne(ctx context.Context, err error) bool {
+	return b.record(ctx, err, false)
+}
+
+func (b *breaker) record(ctx context.Context, err error, runWide bool) bool {
+	switch {
+	case err == nil:
+		b.mu.Lock()
+		b.failures = 0
+		b.mu.Unlock()
+		return false
+	case ctx.Err() != nil:
+		return false
+	case isFatal(err) && (runWide || !errors.Is(err, ErrPermission)):
+		b.abort.Do(func() {
+			b.logf("Stopping the run: %v\n", err)
+			b.cancel(fmt.Errorf("run aborted: %w", err))
+		})
+		return false
+	case !isTransient(err):
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.paused != nil {
+		return true // failed while others were already pausing
+	}
+	b.failures++
+	if b.failures < breakerThreshold {
+		return false
+	}
+	b.paused = make(chan struct{})
+	b.logf("%d requests failed in a row (last: %v); pausing until the server answers %s\n", b.failures, err, b.c.API.Ping)
+	go b.recover(ctx, b.paused)
+	return true
+}
+
+// recover polls /server/ping with exponential backoff and lifts the pause
+// once it answers.
+func (b *breaker) recover(ctx context.Context, paused chan struct{}) {
+	start := time.Now()
+	backoff := breakerMinBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if status, body, _, err := b.c.probe(ctx, b.c.BaseURL, b.c.API.Ping); err == nil && isPong(status, body) {
+			break
+		}
+		backoff = min(2*backoff, breakerMaxBackoff)
+	}
+	b.mu.Lock()
+	b.failures = 0
+	b.paused = nil
+	b.mu.Unlock()
+	close(paused)
+	b.logf("Server is back after %s; resuming\n", time.Since(start).Round(time.Second))
+}
@@ -0,0 +1,253 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checksumCache maps a file identity (path + size + mtime + inode) to its sha1,
+// so re-scanned albums don't have to be re-read from disk on every run.
+type checksumCache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]checksumCacheEntry
+	seen    map[string]bool
+	changed map[string]bool // stored or pruned by this run
+	dirty   bool
+	rebuild bool
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type checksumCacheEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Inode   uint64 `json:"inode,omitempty"`
+	SHA1    string `json:"sha1"`
+}
+
+type checksumCacheFile struct {
+	Version int                           `json:"version"`
+	Entries map[string]checksumCacheEntry `json:"entries"`
+}
+
+const checksumCacheVersion = 1
+
+// checksumCacheSaveInterval is how often a run saves the cache while it
+// goes on, so an interrupted run keeps most of what it hashed.
+const checksumCacheSaveInterval = time.Minute
+
+func defaultChecksumCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "immich-uploader-checksums.json"
+	}
+	return filepath.Join(dir, "immich-uploader", "checksums.json")
+}
+
+// openChecksumCache loads the cache at path. A missing or unreadable cache file
+// is not an error: the cache simply starts empty. If rebuild is true the
+// existing contents are discarded.
+func openChecksumCache(path string, rebuild bool) *checksumCache {
+	if path == "" {
+		path = defaultChecksumCachePath()
+	}
+	cc := &checksumCache{
+		path:    path,
+		entries: map[string]checksumCacheEntry{},
+		seen:    map[string]bool{},
+		changed: map[string]bool{},
+		rebuild: rebuild,
+	}
+	if rebuild {
+		cc.dirty = true
+		return cc
+	}
+	entries, err := readChecksumCache(path)
+	switch {
+	case err == nil:
+		cc.entries = entries
+	case !errors.Is(err, os.ErrNotExist):
+		cc.dirty = true
+	}
+	return cc
+}
+
+// readChecksumCache reads the entries of the cache file at path. An outdated
+// version is an error.
+func readChecksumCache(path string) (map[string]checksumCacheEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f checksumCacheFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	if f.Version != checksumCacheVersion {
+		return nil, fmt.Errorf("checksum cache version %d", f.Version)
+	}
+	if f.Entries == nil {
+		f.Entries = map[string]checksumCacheEntry{}
+	}
+	return f.Entries, nil
+}
+
+func cacheKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// keep marks e as still under its root, so prune keeps its entry even if the
+// file is left in place before it is hashed.
+func (cc *checksumCache) keep(e fileEntry) {
+	key := cacheKey(e.path)
+	cc.mu.Lock()
+	cc.seen[key] = true
+	cc.mu.Unlock()
+}
+
+// lookup returns the cached sha1 for e if its size, mtime and inode still match.
+func (cc *checksumCache) lookup(e fileEntry) (string, bool) {
+	key := cacheKey(e.path)
+	cc.mu.Lock()
+	cc.seen[key] = true
+	ce, ok := cc.entries[key]
+	cc.mu.Unlock()
+
+	if ok && ce.Size == e.size && ce.ModTime == e.modTime.UnixNano() && ce.Inode == e.inode() {
+		cc.hits.Add(1)
+		return ce.SHA1, true
+	}
+	cc.misses.Add(1)
+	return "", false
+}
+
+func (cc *checksumCache) store(e fileEntry, sum string) {
+	key := cacheKey(e.path)
+	ce := checksumCacheEntry{
+		Size:    e.size,
+		ModTime: e.modTime.UnixNano(),
+		Inode:   e.inode(),
+		SHA1:    sum,
+	}
+	cc.mu.Lock()
+	cc.entries[key] = ce
+	cc.seen[key] = true
+	cc.changed[key] = true
+	cc.dirty = true
+	cc.mu.Unlock()
+}
+
+// checksum returns the sha1 of e, consulting and updating the cache.
+func (cc *checksumCache) checksum(e fileEntry) (string, error) {
+	if sum, ok := cc.lookup(e); ok {
+		return sum, nil
+	}
+	sum, err := sha1File(e.path)
+	if err != nil {
+		return "", err
+	}
+	cc.store(e, sum)
+	return sum, nil
+}
+
+// prune drops entries under root whose files were not scanned during this run
+// (typically files that were uploaded and moved into the ignore folder).
+func (cc *checksumCache) prune(root string) {
+	prefix := cacheKey(root) + string(filepath.Separator)
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for k := range cc.entries {
+		if strings.HasPrefix(k, prefix) && !cc.seen[k] {
+			delete(cc.entries, k)
+			cc.changed[k] = true
+			cc.dirty = true
+		}
+	}
+}
+
+// save writes the cache back. Runs on other roots share the file, so what
+// they saved since it was loaded is merged in, and only this run's own
+// changes are applied on top. A rebuilt cache replaces the file.
+func (cc *checksumCache) save() error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if !cc.dirty {
+		return nil
+	}
+	if disk, err := readChecksumCache(cc.path); err == nil && !cc.rebuild {
+		for k := range cc.changed {
+			if e, ok := cc.entries[k]; ok {
+				disk[k] = e
+			} else {
+				delete(disk, k)
+			}
+		}
+		cc.entries = disk
+	}
+	b, err := json.Marshal(checksumCacheFile{Version: checksumCacheVersion, Entries: cc.entries})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cc.path), 0o755); err != nil {
+		return err
+	}
+	// write to a temp file and rename so an interrupted run can't leave a
+	// truncated cache; the name is unique since other runs may save at once
+	f, err := os.CreateTemp(filepath.Dir(cc.path), filepath.Base(cc.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), cc.path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	cc.dirty = false
+	return nil
+}
+
+// autosave saves the cache every interval until ctx is done; the run saves it
+// once more at the end.
+func (cc *checksumCache) autosave(ctx context.Context, interval time.Duration, logf Logf) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := cc.save(); err != nil {
+				logf("save checksum cache: %v\n", err)
+			}
+		}
+	}
+}
+
+// stats returns hit/miss counters and the hit rate in percent.
+func (cc *checksumCache) stats() (hits, misses int64, rate float64) {
+	hits, misses = cc.hits.Load(), cc.misses.Load()
+	if total := hits + misses; total > 0 {
+		rate = float64(hits) * 100 / float64(total)
+	}
+	return hits, misses, rate
+}
@@ -0,0 +1,90 @@
+package uploader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func cacheEntry(t *testing.T, path string) fileEntry {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(path), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newFileEntry(filepath.Base(filepath.Dir(path)), filepath.Dir(path), path, st)
+}
+
+func TestChecksumCacheConcurrentRuns(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "checksums.json")
+	rootA, rootB := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	a1 := cacheEntry(t, filepath.Join(rootA, "album", "1.jpg"))
+	a2 := cacheEntry(t, filepath.Join(rootA, "album", "2.jpg"))
+	b1 := cacheEntry(t, filepath.Join(rootB, "album", "1.jpg"))
+
+	first := openChecksumCache(path, false)
+	if _, err := first.checksum(a1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.checksum(a2); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two runs on different roots load the cache at the same time.
+	runA := openChecksumCache(path, false)
+	runB := openChecksumCache(path, false)
+	if _, err := runA.checksum(a1); err != nil { // a2 was uploaded and moved away
+		t.Fatal(err)
+	}
+	runA.prune(rootA)
+	if _, err := runB.checksum(b1); err != nil {
+		t.Fatal(err)
+	}
+	if err := runB.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := runA.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readChecksumCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []fileEntry{a1, b1} {
+		if _, ok := entries[cacheKey(e.path)]; !ok {
+			t.Errorf("%s lost from the cache", e.path)
+		}
+	}
+	if _, ok := entries[cacheKey(a2.path)]; ok {
+		t.Errorf("pruned %s is back in the cache", a2.path)
+	}
+	if len(entries) != 2 {
+		t.Errorf("cache has %d entries, want 2", len(entries))
+	}
+
+	// A rebuilt cache replaces the file.
+	rebuilt := openChecksumCache(path, true)
+	if _, err := rebuilt.checksum(b1); err != nil {
+		t.Fatal(err)
+	}
+	if err := rebuilt.save(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := readChecksumCache(path); len(entries) != 1 {
+		t.Errorf("rebuilt cache has %d entries, want 1", len(entries))
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) > 0 {
+		t.Errorf("temp files left: %v", tmp)
+	}
+}
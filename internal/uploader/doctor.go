@@ -0,0 +1,385 @@
+package uploader
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"immich-uploader/immich"
+)
+
+// Doctor checks (also run at the start of every upload):
+// - GET /server/ping     (ServerPingResponse) — reachability and the /api suffix
+// - GET /server/version  (ServerVersionResponseDto) — selects the API adapter
+// - GET /users/me        (UserAdminResponseDto) — API key accepted
+// - GET /api-keys/me     (APIKeyResponseDto) — key permissions
+// - GET /server/storage  (ServerStorageResponseDto) — free space, see storage.go
+
+// requiredPermissions are the API key permissions of the endpoints we call.
+var requiredPermissions = []string{"album.read", "album.create", "albumAsset.create", "asset.upload"}
+
+// CheckLevel is the outcome of a doctor check.
+type CheckLevel int
+
+const (
+	CheckOK CheckLevel = iota
+	CheckWarn
+	CheckFail
+)
+
+func (l CheckLevel) String() string {
+	switch l {
+	case CheckOK:
+		return "ok"
+	case CheckWarn:
+		return "warn"
+	default:
+		return "FAIL"
+	}
+}
+
+// CheckResult is one line of the doctor report. Hint says what to change.
+type CheckResult struct {
+	Name   string
+	Level  CheckLevel
+	Detail string
+	Hint   string
+	// Err is the kind of failure (ErrNetwork, ErrAuth, ErrPermission), if
+	// known, so a run failing the check can be told apart with errors.Is.
+	Err error
+}
+
+func (r CheckResult) String() string {
+	s := fmt.Sprintf("[%s] %s: %s", r.Level, r.Name, r.Detail)
+	if r.Hint != "" {
+		s += "\n       -> " + r.Hint
+	}
+	return s
+}
+
+// Doctor diagnoses the connection, credentials and root folder described by
+// opt, logs a report and returns an error if any check failed.
+func Doctor(ctx context.Context, opt Options, logf Logf) error {
+	if logf == nil {
+		logf = func(format string, args ...any) { fmt.Fprintf(os.Stdout, format, args...) }
+	}
+	var link *immich.SharedLink
+	if opt.SharedLink != "" {
+		var err error
+		if link, err = immich.ParseSharedLink(opt.SharedLink, opt.SharedLinkPassword); err != nil {
+			return err
+		}
+	}
+	secrets := append([]string{opt.APIKey, opt.Password}, headerSecrets(opt.Headers)...)
+	if link != nil {
+		secrets = append(secrets, link.Key, link.Password)
+	}
+	logf = redactLogf(logf, secrets...)
+
+	trace := newTracer(opt, logf, secrets)
+	defer func() {
+		if err := trace.writeHAR(); err != nil {
+			logf("%v\n", err)
+		}
+	}()
+	check, err := newValidator(opt, logf)
+	if err != nil {
+		return err
+	}
+	if check != nil {
+		check.strict = false // report mismatches, don't turn them into failed checks
+	}
+	defer check.summary()
+	hc, _, err := newHTTPClients(opt, trace, check)
+	if err != nil {
+		return err
+	}
+	c := newClient(opt.BaseURL, immich.APIKey(opt.APIKey), hc, nil)
+	results := c.diagnose(ctx)
+	if !failed(results) {
+		switch {
+		case link != nil:
+			c.Auth = link
+			r := CheckResult{Name: "shared link", Level: CheckOK}
+			if album, err := c.openSharedLink(ctx); err != nil {
+				r.Level, r.Detail = CheckFail, err.Error()
+				r.Hint = "ask the owner for a new link with \"allow upload\" enabled"
+			} else {
+				r.Detail = fmt.Sprintf("uploads go into album %q", album.AlbumName)
+			}
+			results = append(results, r)
+		case opt.APIKey == "" && opt.Email != "":
+			c.Auth = &immich.Session{Email: opt.Email, Password: opt.Password}
+			r := CheckResult{Name: "login", Level: CheckOK}
+			if user, err := c.login(ctx); err != nil {
+				r.Level, r.Detail = CheckFail, err.Error()
+				r.Hint = "check the email and password by logging in to the web UI"
+			} else {
+				r.Detail = fmt.Sprintf("logged in as %s (%s)", user.Name, user.UserEmail)
+				defer func() { _ = c.logout() }()
+			}
+			results = append(results, r)
+		case opt.APIKey == "":
+			results = append(results, CheckResult{Name: "credentials", Level: CheckFail, Detail: "no API key, email/password or shared link",
+				Hint: "pass --key-file, set IMMICH_API_KEY, or use --email / --shared-link"})
+		default:
+			results = append(results, c.checkAPIKey(ctx)...)
+		}
+		if !failed(results) {
+			results = append(results, c.checkStorage(ctx))
+		}
+	}
+	results = append(results, checkRoot(opt))
+
+	for _, r := range results {
+		logf("%s\n", r)
+	}
+	if failed(results) {
+		return immich.RedactError(errors.New("doctor: some checks failed"), secrets...)
+	}
+	logf("All checks passed.\n")
+	return nil
+}
+
+// checkServer runs the server checks at the start of a run. Warnings are
+// logged; the first failure is returned as an error with its hint.
+func (c *client) checkServer(ctx context.Context, logf Logf) error {
+	results := c.diagnose(ctx)
+	if _, ok := c.Auth.(immich.APIKey); ok && !failed(results) {
+		results = append(results, c.checkAPIKey(ctx)...)
+	}
+	for _, r := range results {
+		switch r.Level {
+		case CheckWarn:
+			logf("warning: %s\n", r)
+		case CheckFail:
+			return &checkError{r}
+		}
+	}
+	return nil
+}
+
+// checkError is a failed check stopping a run.
+type checkError struct{ r CheckResult }
+
+func (e *checkError) Error() string {
+	if e.r.Hint != "" {
+		return fmt.Sprintf("%s: %s (%s)", e.r.Name, e.r.Detail, e.r.Hint)
+	}
+	return fmt.Sprintf("%s: %s", e.r.Name, e.r.Detail)
+}
+
+func (e *checkError) Unwrap() error { return e.r.Err }
+
+func failed(results []CheckResult) bool {
+	for _, r := range results {
+		if r.Level == CheckFail {
+			return true
+		}
+	}
+	return false
+}
+
+// probe performs an authorized GET and returns the raw response.
+func (c *client) probe(ctx context.Context, baseURL, path string) (int, []byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+path, nil)
+	if err != nil {
+		return 0, nil, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	c.Auth.Authorize(req)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, nil, "", err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode, b, resp.Header.Get("Content-Type"), nil
+}
+
+func isPong(status int, body []byte) bool {
+	var ping struct {
+		Res string `json:"res"`
+	}
+	return status == http.StatusOK && json.Unmarshal(body, &ping) == nil && ping.Res == "pong"
+}
+
+// diagnose checks that the base URL points at an Immich API, reads the
+// server version and selects the matching API adapter.
+func (c *client) diagnose(ctx context.Context) []CheckResult {
+	r := CheckResult{Name: "server", Level: CheckOK}
+	var (
+		ping   string
+		status int
+		body   []byte
+		ctype  string
+		err    error
+	)
+	for _, ping = range immich.PingPaths() {
+		status, body, ctype, err = c.probe(ctx, c.BaseURL, ping)
+		if err != nil || isPong(status, body) {
+			break
+		}
+	}
+	switch {
+	case err != nil:
+		r.Level, r.Detail = CheckFail, fmt.Sprintf("cannot reach %s: %v", c.BaseURL, immich.Redact(err.Error(), c.secrets()...))
+		r.Err = ErrNetwork
+		var unknownCA x509.UnknownAuthorityError
+		switch {
+		case errors.As(err, &unknownCA):
+			r.Hint = "the server's certificate is signed by a private CA: pass its PEM bundle with --ca-cert"
+		case strings.Contains(err.Error(), "certificate required"):
+			r.Hint = "the server (or its proxy) requires a client certificate: pass --client-cert and --client-key"
+		default:
+			r.Hint = "check the host, port and scheme (http/https) of --immich"
+		}
+		return []CheckResult{r}
+	case isPong(status, body):
+		r.Detail = "reachable at " + c.BaseURL
+	default:
+		r.Level = CheckFail
+		r.Detail = fmt.Sprintf("%s%s returned status %d (%s), not an Immich API", c.BaseURL, ping, status, ctype)
+		if !strings.HasSuffix(c.BaseURL, "/api") {
+			for _, p := range immich.PingPaths() {
+				if st, b, _, err := c.probe(ctx, c.BaseURL+"/api", p); err == nil && isPong(st, b) {
+					r.Hint = fmt.Sprintf("the URL is missing the /api suffix: use --immich %s/api", c.BaseURL)
+					return []CheckResult{r}
+				}
+			}
+		}
+		if status == http.StatusForbidden || status == http.StatusUnauthorized {
+			r.Hint = "an access proxy in front of Immich may have rejected the request: pass its credentials with --header"
+		} else if strings.Contains(ctype, "text/html") {
+			r.Hint = "this is a web page (reverse proxy, login portal or the web UI?); point --immich at the server's /api"
+		} else {
+			r.Hint = "point --immich at the Immich server's API, e.g. http://host:2283/api"
+		}
+		return []CheckResult{r}
+	}
+	results := []CheckResult{r}
+
+	v := CheckResult{Name: "version", Level: CheckOK}
+	var ver immich.ServerVersion
+	status, body, _, err = c.probe(ctx, c.BaseURL, immich.VersionPath(ping))
+	if err != nil || status != http.StatusOK || json.Unmarshal(body, &ver) != nil {
+		v.Level, v.Detail = CheckWarn, "could not read the server version; assuming the "+immich.Latest.Name+" API"
+		return append(results, v)
+	}
+	api, err := immich.APIFor(ver)
+	if err != nil {
+		v.Level, v.Detail = CheckFail, err.Error()
+		return append(results, v)
+	}
+	c.API = api
+	v.Detail = fmt.Sprintf("Immich %s (%s API)", ver, api.Name)
+	return append(results, v)
+}
+
+// checkAPIKey verifies that the API key is accepted and has the permissions
+// of every endpoint an upload uses.
+func (c *client) checkAPIKey(ctx context.Context) []CheckResult {
+	r := CheckResult{Name: "API key", Level: CheckOK}
+	status, body, _, err := c.probe(ctx, c.BaseURL, c.API.UsersMe)
+	switch {
+	case err != nil:
+		r.Level, r.Detail, r.Err = CheckFail, immich.Redact(err.Error(), c.secrets()...), ErrNetwork
+		return []CheckResult{r}
+	case status == http.StatusUnauthorized:
+		r.Level, r.Detail, r.Err = CheckFail, "rejected by the server (invalid or revoked)", ErrAuth
+		r.Hint = "create a new key under Account Settings > API Keys"
+		return []CheckResult{r}
+	case status == http.StatusForbidden:
+		r.Level, r.Detail = CheckWarn, "accepted, but it may not read the user profile (user.read)"
+	case status == http.StatusOK:
+		var me struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		}
+		_ = json.Unmarshal(body, &me)
+		r.Detail = fmt.Sprintf("accepted for %s (%s)", me.Name, me.Email)
+	default:
+		r.Level, r.Detail = CheckWarn, fmt.Sprintf("%s returned status %d", c.API.UsersMe, status)
+	}
+	results := []CheckResult{r}
+
+	p := CheckResult{Name: "permissions", Level: CheckOK}
+	var key struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+	if c.API.APIKeysMe == "" {
+		p.Level, p.Detail = CheckWarn, "this server release cannot report key permissions"
+		return append(results, p)
+	}
+	status, body, _, err = c.probe(ctx, c.BaseURL, c.API.APIKeysMe)
+	if err != nil || status != http.StatusOK || json.Unmarshal(body, &key) != nil {
+		p.Level, p.Detail = CheckWarn, "could not read the key's permissions"
+		return append(results, p)
+	}
+	have := map[string]bool{}
+	for _, perm := range key.Permissions {
+		have[perm] = true
+	}
+	var missing []string
+	for _, perm := range requiredPermissions {
+		if !have["all"] && !have[perm] {
+			missing = append(missing, perm)
+		}
+	}
+	if len(missing) > 0 {
+		p.Level, p.Err = CheckFail, ErrPermission
+		p.Detail = fmt.Sprintf("key %q lacks %s", key.Name, strings.Join(missing, ", "))
+		p.Hint = "edit the key (or create a new one) with these permissions: " + strings.Join(requiredPermissions, ", ")
+	} else {
+		p.Detail = fmt.Sprintf("key %q can upload and manage albums", key.Name)
+	}
+	return append(results, p)
+}
+
+// checkStorage reports the server's free disk space and the user's quota.
+func (c *client) checkStorage(ctx context.Context) CheckResult {
+	s := c.space(ctx)
+	r := CheckResult{Name: "storage", Level: CheckOK, Detail: s.String()}
+	switch limit := s.limit(); {
+	case limit == 0:
+		r.Level, r.Hint = CheckFail, "free up space on the server or raise the user's storage quota"
+	case limit < 0:
+		r.Level, r.Hint = CheckWarn, "runs cannot tell beforehand whether an upload fits"
+	}
+	return r
+}
+
+// checkRoot verifies that the root folder exists and lists its album folders.
+func checkRoot(opt Options) CheckResult {
+	r := CheckResult{Name: "root", Level: CheckOK}
+	if opt.Root == "" {
+		r.Level, r.Detail, r.Hint = CheckFail, "not set", "pass --root with the folder that contains album folders"
+		return r
+	}
+	entries, err := os.ReadDir(opt.Root)
+	if err != nil {
+		r.Level, r.Detail = CheckFail, err.Error()
+		r.Hint = "check the path and that this user can read it"
+		return r
+	}
+	albums := 0
+	for _, e := range entries {
+		if e.IsDir() && e.Name() != opt.IgnoreDir {
+			albums++
+		}
+	}
+	r.Detail = fmt.Sprintf("%s has %d album folder(s)", filepath.Clean(opt.Root), albums)
+	if albums == 0 {
+		r.Level = CheckWarn
+		r.Hint = "each subfolder of --root becomes an album; files directly in --root are not uploaded"
+	}
+	return r
+}
@@ -0,0 +1,501 @@
+package uploader_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"immich-uploader/immich"
+	"immich-uploader/immich/immichtest"
+	"immich-uploader/internal/config"
+	"immich-uploader/internal/uploader"
+)
+
+// End-to-end runs against the fake server in immich/immichtest. Every run
+// validates its requests and responses against the bundled OpenAPI spec, so
+// the fake can't drift from the real server's contract unnoticed.
+
+// testdataAlbums is the content of ../../testdata: six copies of the same
+// photo in three album folders.
+var testdataAlbums = map[string][]string{
+	"Beach Trip": {"beach-1.jpg", "beach-2.jpg"},
+	"Birthday":   {"bday-1.jpg"},
+	"Random":     {"random-1.jpg", "random-2.jpg", "random-3.jpg"},
+}
+
+// copyTestdata copies ../../testdata into a new root, since runs move the
+// files they upload.
+func copyTestdata(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	src := filepath.Join("..", "..", "testdata")
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(root, rel), 0o755)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(root, rel), b, 0o644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// writeAlbums creates album folders of small files with distinct contents.
+func writeAlbums(t *testing.T, albums map[string]int) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, n := range albums {
+		dir := filepath.Join(root, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for i := range n {
+			data := fmt.Appendf(nil, "%s photo %d", name, i)
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("img-%d.jpg", i)), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+// options are the command's defaults, pointed at srv.
+func options(t *testing.T, srv *immichtest.Server, root string) uploader.Options {
+	t.Helper()
+	opt, err := config.Defaults().Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt.BaseURL = srv.URL
+	opt.APIKey = srv.APIKey
+	opt.Root = root
+	opt.NoChecksumCache = true
+	opt.SettleTime = 0 // the files were just written
+	opt.ValidateOpenAPI = uploader.ValidateStrict
+	opt.TUI, opt.TUIAuto, opt.NoANSI = false, false, true
+	return opt
+}
+
+func run(t *testing.T, opt uploader.Options) (uploader.Summary, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return uploader.RunSummary(ctx, opt, t.Logf)
+}
+
+// remaining lists the files under root outside the ignore folder.
+func remaining(t *testing.T, root string) []string {
+	t.Helper()
+	var out []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		switch {
+		case d.IsDir() && rel == "ignore":
+			return filepath.SkipDir
+		case !d.IsDir() && filepath.Base(path)[0] != '.':
+			out = append(out, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestUploadTestdata(t *testing.T) {
+	srv := immichtest.NewServer()
+	defer srv.Close()
+	root := copyTestdata(t)
+
+	sum, err := run(t, options(t, srv, root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Files != 6 || sum.Duplicates != 5 || sum.Failed != 0 || sum.Skipped != 0 {
+		t.Errorf("summary = %v, want 6 files of which 5 duplicates", sum)
+	}
+
+	// Identical files are stored once and added to every album.
+	assets := srv.Assets()
+	if len(assets) != 1 {
+		t.Fatalf("server has %d assets, want 1", len(assets))
+	}
+	albums := srv.Albums()
+	if len(albums) != len(testdataAlbums) {
+		t.Errorf("server has %d albums, want %d", len(albums), len(testdataAlbums))
+	}
+	for name, files := range testdataAlbums {
+		a, ok := srv.Album(name)
+		if !ok {
+			t.Errorf("album %q not created", name)
+			continue
+		}
+		if !slices.Equal(a.AssetIDs, []string{assets[0].ID}) {
+			t.Errorf("album %q has assets %v, want [%s]", name, a.AssetIDs, assets[0].ID)
+		}
+		for _, f := range files {
+			if _, err := os.Stat(filepath.Join(root, "ignore", name, f)); err != nil {
+				t.Errorf("%s/%s not moved into the ignore folder: %v", name, f, err)
+			}
+		}
+	}
+	if left := remaining(t, root); len(left) > 0 {
+		t.Errorf("files left in place: %v", left)
+	}
+}
+
+func TestRerunFindsDuplicates(t *testing.T) {
+	srv := immichtest.NewServer()
+	defer srv.Close()
+	if _, err := run(t, options(t, srv, copyTestdata(t))); err != nil {
+		t.Fatal(err)
+	}
+	uploads := srv.Count("POST", "/assets")
+
+	// The same files in a new root: the preflight finds them all, and the
+	// existing albums are reused.
+	root := copyTestdata(t)
+	sum, err := run(t, options(t, srv, root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Files != 6 || sum.Duplicates != 6 {
+		t.Errorf("summary = %v, want 6 duplicates", sum)
+	}
+	if n := srv.Count("POST", "/assets") - uploads; n != 0 {
+		t.Errorf("%d files uploaded again", n)
+	}
+	if n := len(srv.Albums()); n != len(testdataAlbums) {
+		t.Errorf("server has %d albums, want %d", n, len(testdataAlbums))
+	}
+	if left := remaining(t, root); len(left) > 0 {
+		t.Errorf("files left in place: %v", left)
+	}
+}
+
+func TestExistingAlbumAndAsset(t *testing.T) {
+	srv := immichtest.NewServer()
+	defer srv.Close()
+	root := writeAlbums(t, map[string]int{"Trip": 3})
+	seen := srv.AddAsset("img-0.jpg", []byte("Trip photo 0"))
+	trip := srv.AddAlbum("Trip")
+
+	opt := options(t, srv, root)
+	opt.DedupeAdd = false
+	sum, err := run(t, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Files != 3 || sum.Duplicates != 1 {
+		t.Errorf("summary = %v, want 3 files of which 1 duplicate", sum)
+	}
+	if n := len(srv.Albums()); n != 1 {
+		t.Errorf("server has %d albums, want the existing one", n)
+	}
+	a, _ := srv.Album("Trip")
+	if a.ID != trip.ID || len(a.AssetIDs) != 2 || slices.Contains(a.AssetIDs, seen.ID) {
+		t.Errorf("album = %+v, want the 2 new assets only (DedupeAdd off)", a)
+	}
+}
+
+func TestFaultsAreRetried(t *testing.T) {
+	srv := immichtest.NewServer()
+	defer srv.Close()
+	srv.Inject(immichtest.Fault{Latency: 20 * time.Millisecond})
+	srv.Inject(immichtest.Fault{Method: "GET", Path: "/albums", Drop: true, Times: 1})
+	srv.Inject(immichtest.Fault{Method: "POST", Path: "/assets/bulk-upload-check", Status: 503, Times: 1})
+	srv.Inject(immichtest.Fault{Method: "POST", Path: "/assets", Status: 429, RetryAfter: time.Second, Times: 1})
+	srv.Inject(immichtest.Fault{Method: "PUT", Path: "/albums/*/assets", Status: 502, Times: 1})
+	root := writeAlbums(t, map[string]int{"A": 3, "B": 2})
+
+	sum, err := run(t, options(t, srv, root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Files != 5 || sum.Failed != 0 {
+		t.Errorf("summary = %v, want 5 files uploaded", sum)
+	}
+	for name, n := range map[string]int{"A": 3, "B": 2} {
+		if a, _ := srv.Album(name); len(a.AssetIDs) != n {
+			t.Errorf("album %q has %d assets, want %d", name, len(a.AssetIDs), n)
+		}
+	}
+	for _, want := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/albums", 0},
+		{"POST", "/assets/bulk-upload-check", 503},
+		{"POST", "/assets", 429},
+		{"PUT", "/albums/*/assets", 502},
+	} {
+		found := false
+		for _, r := range srv.Requests() {
+			if r.Method == want.method && r.Status == want.status {
+				if ok, _ := filepath.Match(want.path, r.Path); ok {
+					found = true
+				}
+			}
+		}
+		if !found {
+			t.Errorf("fault %s %s %d never hit", want.method, want.path, want.status)
+		}
+	}
+}
+
+func TestFailedUploadIsLeftInPlace(t *testing.T) {
+	for _, f := range []immichtest.Fault{
+		{Method: "POST", Path: "/assets", Status: 500, Times: 1},
+		{Method: "POST", Path: "/assets", Drop: true, Times: 1},
+	} {
+		name := fmt.Sprint(f.Status)
+		if f.Drop {
+			name = "drop"
+		}
+		t.Run(name, func(t *testing.T) {
+			srv := immichtest.NewServer()
+			defer srv.Close()
+			srv.Inject(f)
+			root := writeAlbums(t, map[string]int{"A": 1})
+			opt := options(t, srv, root)
+
+			sum, err := run(t, opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sum.Failed != 1 || sum.Files != 0 {
+				t.Errorf("summary = %v, want 1 failed", sum)
+			}
+			if left := remaining(t, root); !slices.Equal(left, []string{"A/img-0.jpg"}) {
+				t.Errorf("files left in place: %v, want A/img-0.jpg", left)
+			}
+
+			// The next run picks it up.
+			if sum, err = run(t, opt); err != nil {
+				t.Fatal(err)
+			}
+			if sum.Files != 1 || len(srv.Assets()) != 1 {
+				t.Errorf("rerun: summary = %v, server has %d assets; want 1 uploaded", sum, len(srv.Assets()))
+			}
+		})
+	}
+}
+
+func TestWrongAPIKey(t *testing.T) {
+	srv := immichtest.NewServer()
+	defer srv.Close()
+	opt := options(t, srv, copyTestdata(t))
+	opt.APIKey = "wrong"
+
+	_, err := run(t, opt)
+	if !errors.Is(err, uploader.ErrAuth) {
+		t.Fatalf("err = %v, want ErrAuth", err)
+	}
+	if n := srv.Count("POST", "*"); n != 0 {
+		t.Errorf("%d POST requests with a rejected key", n)
+	}
+}
+
+func TestPermissionErrors(t *testing.T) {
+	// A 403 on one upload fails that file only.
+	srv := immichtest.NewServer()
+	defer srv.Close()
+	srv.Inject(immichtest.Fault{Method: "POST", Path: "/assets", Status: 403, Times: 1})
+	root := writeAlbums(t, map[string]int{"A": 3})
+	opt := options(t, srv, root)
+	opt.Workers = 1
+	sum, err := run(t, opt)
+	if err != nil || sum.Failed != 1 || sum.Files != 2 {
+		t.Errorf("403 on one upload: %v, %v; want 1 failed, 2 uploaded", sum, err)
+	}
+
+	// On bulk-upload-check, which every file needs, it stops the run.
+	srv = immichtest.NewServer()
+	defer srv.Close()
+	srv.Inject(immichtest.Fault{Method: "POST", Path: "/assets/bulk-upload-check", Status: 403})
+	_, err = run(t, options(t, srv, writeAlbums(t, map[string]int{"A": 3})))
+	if !errors.Is(err, uploader.ErrPermission) {
+		t.Errorf("403 on bulk-upload-check: %v, want ErrPermission", err)
+	}
+	if n := srv.Count("POST", "/assets"); n != 0 {
+		t.Errorf("%d uploads after the run was stopped", n)
+	}
+}
+
+func TestServerFull(t *testing.T) {
+	srv := immichtest.NewServer()
+	srv.DiskSize = 30 // 3 files of 9 bytes
+	defer srv.Close()
+	root := writeAlbums(t, map[string]int{"A": 4})
+
+	// The space check starts no upload that would not fit.
+	sum, err := run(t, options(t, srv, root))
+	if !errors.Is(err, uploader.ErrServerFull) {
+		t.Fatalf("err = %v, want ErrServerFull", err)
+	}
+	if n := len(srv.Assets()); n != 3 || sum.Failed != 0 {
+		t.Errorf("%d assets uploaded, %d failed; want the 3 that fit and no failures", n, sum.Failed)
+	}
+	if left := remaining(t, root); len(left) != 1 {
+		t.Errorf("files left in place: %v, want 1", left)
+	}
+
+	// Files the server already has don't count against its free space.
+	if sum, err := run(t, options(t, srv, writeAlbums(t, map[string]int{"A": 3}))); err != nil || sum.Duplicates != 3 {
+		t.Errorf("re-run over duplicates: %v, %v; want 3 duplicates", sum, err)
+	}
+
+	// Without the check, the upload fails once the disk is full.
+	opt := options(t, srv, root)
+	opt.SpaceCheck = uploader.SpaceCheckOff
+	sum, err = run(t, opt)
+	if !errors.Is(err, uploader.ErrServerFull) || sum.Failed != 1 {
+		t.Fatalf("without the check: %v, %v; want 1 failed and ErrServerFull", sum, err)
+	}
+	if left := remaining(t, root); len(left) != 1 {
+		t.Errorf("files left in place: %v, want 1", left)
+	}
+}
+
+func TestChecksumCacheKeepsSkippedFiles(t *testing.T) {
+	srv := immichtest.NewServer()
+	srv.DiskSize = 30 // 3 files of 9 bytes
+	defer srv.Close()
+	root := writeAlbums(t, map[string]int{"A": 4})
+	opt := options(t, srv, root)
+	opt.NoChecksumCache = false
+	opt.ChecksumCache = filepath.Join(t.TempDir(), "checksums.json")
+
+	sum, _ := run(t, opt)
+	if sum.CacheHits != 0 || sum.CacheMisses != 4 {
+		t.Fatalf("first run: %d cache hits, %d misses; want 0 and 4", sum.CacheHits, sum.CacheMisses)
+	}
+
+	// The file left in place is skipped before hashing, as one still being
+	// written; its entry must outlive the run.
+	settle := opt
+	settle.SettleTime = time.Hour
+	if sum, _ := run(t, settle); sum.Skipped != 1 || sum.CacheHits+sum.CacheMisses != 0 {
+		t.Fatalf("settle run: %v; want 1 skipped and no cache lookups", sum)
+	}
+
+	sum, _ = run(t, opt)
+	if sum.CacheHits != 1 || sum.CacheMisses != 0 {
+		t.Errorf("last run: %d cache hits, %d misses; want 1 and 0", sum.CacheHits, sum.CacheMisses)
+	}
+}
+
+func TestWatchServerFull(t *testing.T) {
+	for _, mode := range []string{uploader.SpaceCheckAbort, uploader.SpaceCheckOff} {
+		t.Run(mode, func(t *testing.T) {
+			srv := immichtest.NewServer()
+			srv.DiskSize = 30 // 3 files of 9 bytes
+			defer srv.Close()
+			root := writeAlbums(t, map[string]int{"A": 4})
+
+			opt := options(t, srv, root)
+			opt.SpaceCheck = mode
+			opt.SettleTime = 50 * time.Millisecond
+			opt.WatchRescan = 100 * time.Millisecond
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- uploader.Watch(ctx, opt, t.Logf) }()
+
+			waitFor := func(what string, ok func() bool) {
+				t.Helper()
+				for !ok() {
+					select {
+					case err := <-done:
+						t.Fatalf("watch stopped waiting for %s: %v", what, err)
+					case <-ctx.Done():
+						t.Fatalf("timed out waiting for %s", what)
+					case <-time.After(20 * time.Millisecond):
+					}
+				}
+			}
+			waitFor("the 3 files that fit", func() bool { return len(srv.Assets()) == 3 })
+			time.Sleep(300 * time.Millisecond) // a few rescans of the file left in place
+			if left := remaining(t, root); len(left) != 1 {
+				t.Fatalf("files left in place: %v, want 1", left)
+			}
+
+			// Once space is freed, the next batch of settled files is uploaded.
+			var ids []string
+			for _, a := range srv.Assets() {
+				ids = append(ids, a.ID)
+			}
+			if err := immich.New(srv.URL, immich.APIKey(srv.APIKey)).DeleteAssets(ctx, ids, true); err != nil {
+				t.Fatal(err)
+			}
+			waitFor("the file left in place", func() bool { return len(remaining(t, root)) == 0 })
+			cancel()
+			if err := <-done; err != nil {
+				t.Errorf("watch: %v", err)
+			}
+			if n := len(srv.Assets()); n != 1 {
+				t.Errorf("%d assets after freeing space, want 1", n)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	srv := immichtest.NewServer()
+	defer srv.Close()
+	root := copyTestdata(t)
+	opt := options(t, srv, root)
+	ctx := context.Background()
+
+	if sum, err := uploader.Verify(ctx, opt, t.Logf); err != nil || sum.Files != 0 {
+		t.Fatalf("before the upload: %v, %v; want nothing to verify", sum, err)
+	}
+	if _, err := run(t, opt); err != nil {
+		t.Fatal(err)
+	}
+	uploads := srv.Count("POST", "/assets")
+	sum, err := uploader.Verify(ctx, opt, t.Logf)
+	if err != nil || sum.Files != 6 || sum.Albums != 3 {
+		t.Fatalf("after the upload: %v, %v; want 6 files in 3 albums verified", sum, err)
+	}
+
+	// A file the server never got, and one it has outside the album.
+	for name, data := range map[string]string{"lost.jpg": "never uploaded", "loose.jpg": "not in the album"} {
+		if err := os.WriteFile(filepath.Join(root, "ignore", "Birthday", name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv.AddAsset("loose.jpg", []byte("not in the album"))
+	sum, err = uploader.Verify(ctx, opt, t.Logf)
+	if err == nil || sum.Files != 8 || sum.Missing != 1 || sum.NotInAlbum != 1 {
+		t.Errorf("with problems: %v, %v; want 1 missing and 1 not in its album", sum, err)
+	}
+
+	// Without DedupeAdd, duplicates are not expected in the album.
+	opt.DedupeAdd = false
+	if sum, _ = uploader.Verify(ctx, opt, t.Logf); sum.Missing != 1 || sum.NotInAlbum != 0 {
+		t.Errorf("without DedupeAdd: %v, want only the missing file", sum)
+	}
+	if n := srv.Count("POST", "/assets") - uploads; n != 0 {
+		t.Errorf("verify uploaded %d files", n)
+	}
+}
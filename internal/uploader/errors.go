@@ -0,0 +1,32 @@
+package uploader
+
+import (
+	"errors"
+
+	"immich-uploader/immich"
+)
+
+// API errors by kind, shared with the immich package. Errors returned by the
+// client match one of these with errors.Is, so callers can react to a class
+// of failure instead of parsing status codes.
+var (
+	ErrAuth       = immich.ErrAuth       // 401: key revoked, session expired
+	ErrPermission = immich.ErrPermission // 403: key lacks a permission
+	ErrNotFound   = immich.ErrNotFound   // 404: album or asset gone
+	ErrServer     = immich.ErrServer     // 5xx
+	ErrNetwork    = immich.ErrNetwork    // connection refused, reset, timeout
+)
+
+// isFatal reports whether err means the credentials can no longer be used,
+// or (strict OpenAPI validation) the server's contract changed, so every
+// further request would fail the same way. A permission error is only fatal
+// for requests every file depends on (see breaker.observeOne).
+func isFatal(err error) bool {
+	return errors.Is(err, ErrAuth) || errors.Is(err, ErrPermission) || errors.Is(err, ErrSpecMismatch)
+}
+
+// isTransient reports whether err is likely to go away once the server (or
+// the network) recovers.
+func isTransient(err error) bool {
+	return (errors.Is(err, ErrServer) || errors.Is(err, ErrNetwork)) && !isOutOfSpace(err)
+}
@@ -0,0 +1,65 @@
+package uploader
+
+import (
+	"context"
+	"time"
+
+	"immich-uploader/immich"
+)
+
+// Hooks let an embedding program change what a run does and observe what it
+// did (see the public uploader package). Nil hooks keep the default behavior.
+// Hooks are called from the run's worker goroutines, possibly concurrently.
+type Hooks struct {
+	// Filter decides whether a file is uploaded, instead of the built-in list
+	// of photo/video extensions. Hidden files are never uploaded.
+	Filter func(f FileInfo) bool
+	// Album returns the Immich album an album folder (its name under Root)
+	// goes into, instead of the folder name. "" skips the folder; an error
+	// skips it and is reported through AlbumDone.
+	Album func(ctx context.Context, folder string) (string, error)
+	// AfterUpload runs once a file is on the server (uploaded, or found to be
+	// a duplicate), instead of moving it into IgnoreDir. An error counts as a
+	// failed move: the file stays uploaded.
+	AfterUpload func(ctx context.Context, f FileInfo, asset immich.UploadResult) error
+
+	// FileDone and AlbumDone report the outcome of every file that entered the
+	// pipeline and of every album folder.
+	FileDone  func(FileOutcome)
+	AlbumDone func(AlbumOutcome)
+}
+
+// FileInfo describes a file found in an album folder.
+type FileInfo struct {
+	Path    string
+	Folder  string // album folder
+	Size    int64
+	ModTime time.Time
+}
+
+func (e fileEntry) info() FileInfo {
+	return FileInfo{Path: e.path, Folder: e.album, Size: e.size, ModTime: e.modTime}
+}
+
+// FileOutcome is what a run did with one file. Asset.ID is set once the
+// server has it (uploaded or duplicate); Skipped or Err say why a file was
+// not (fully) handled.
+type FileOutcome struct {
+	FileInfo
+	Asset   immich.UploadResult
+	Skipped string // why the file was left in place for a later run
+	Err     error  // upload error
+	// AfterErr is the error of moving the uploaded file (or of
+	// Hooks.AfterUpload).
+	AfterErr error
+}
+
+// AlbumOutcome is what a run did with one album folder. Album is empty if
+// the folder was skipped.
+type AlbumOutcome struct {
+	Folder  string
+	Album   string
+	AlbumID string
+	Added   int   // assets added to the album
+	Err     error // resolving or creating the album, or adding assets to it
+}
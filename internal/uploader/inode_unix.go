@@ -0,0 +1,13 @@
+//go:build !windows
+
+package uploader
+
+import "syscall"
+
+// fileInode returns the inode number from the FileInfo.Sys() value.
+func fileInode(_ string, sys any) uint64 {
+	if st, ok := sys.(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
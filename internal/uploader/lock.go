@@ -0,0 +1,268 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	lockFileName      = ".immich-uploader.lock"
+	lockHeartbeat     = 30 * time.Second
+	lockStaleAfter    = 3 * lockHeartbeat
+	lockRetryInterval = 2 * time.Second
+)
+
+// ErrLocked is returned (wrapped) when another run holds the lock on the root.
+var ErrLocked = errors.New("root is locked by another run")
+
+// lockInfo is the content of the lock file.
+type lockInfo struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	Started   time.Time `json:"started"`
+	Heartbeat time.Time `json:"heartbeat"`
+}
+
+func (l lockInfo) String() string {
+	if l.PID == 0 {
+		return fmt.Sprintf("lock file unreadable, modified %s ago", time.Since(l.Heartbeat).Round(time.Second))
+	}
+	return fmt.Sprintf("pid %d on %s, started %s, last heartbeat %s ago",
+		l.PID, l.Host, l.Started.Format(time.DateTime), time.Since(l.Heartbeat).Round(time.Second))
+}
+
+// rootLock is an exclusive lock on an upload root, held by creating a lock
+// file in it. The holder refreshes the heartbeat while it runs; a lock whose
+// heartbeat is older than lockStaleAfter, or whose process is gone (same host
+// only), is considered stale and taken over.
+type rootLock struct {
+	path string
+	info lockInfo
+	stop chan struct{}
+	done chan struct{}
+}
+
+// acquireRootLock takes the lock on root. If another live run holds it, it
+// fails with ErrLocked, or with wait > 0 retries until the lock is free, wait
+// has elapsed or ctx is done.
+func acquireRootLock(ctx context.Context, root string, wait time.Duration, logf Logf) (*rootLock, error) {
+	host, _ := os.Hostname()
+	now := time.Now()
+	l := &rootLock{
+		path: filepath.Join(root, lockFileName),
+		info: lockInfo{PID: os.Getpid(), Host: host, Started: now, Heartbeat: now},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	deadline := time.Now().Add(wait)
+	logged := false
+	for {
+		holder, err := l.tryCreate()
+		if err == nil {
+			go l.heartbeat(logf)
+			return l, nil
+		}
+		if !errors.Is(err, ErrLocked) {
+			return nil, err
+		}
+		if wait <= 0 || time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w (%s); remove %s if that run is gone", ErrLocked, holder, l.path)
+		}
+		if !logged {
+			logf("Waiting for the run holding %s (%s)\n", l.path, holder)
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// tryCreate creates the lock file, taking it over if it is stale. On
+// ErrLocked it returns the current holder.
+func (l *rootLock) tryCreate() (lockInfo, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		err := l.create()
+		if err == nil {
+			return lockInfo{}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return lockInfo{}, fmt.Errorf("create lock file: %w", err)
+		}
+
+		st, err := os.Stat(l.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // released meanwhile
+		}
+		if err != nil {
+			return lockInfo{}, fmt.Errorf("read lock file: %w", err)
+		}
+		holder, err := readLockInfo(l.path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			continue
+		case err == nil && !holder.stale(l.info.Host):
+			return holder, ErrLocked
+		case err != nil && time.Since(st.ModTime()) <= lockStaleAfter:
+			// Unreadable but recent: still being written (by a run on a
+			// filesystem without hard links), or damaged only just now.
+			return lockInfo{Heartbeat: st.ModTime()}, ErrLocked
+		}
+		if err := l.removeStale(st); err != nil {
+			return holder, err
+		}
+	}
+	holder, _ := readLockInfo(l.path)
+	return holder, ErrLocked
+}
+
+// create creates the lock file with its full content, failing with an
+// os.ErrExist error if there already is one.
+func (l *rootLock) create() error {
+	tmp, err := l.writeTemp()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	err = os.Link(tmp, l.path)
+	if err == nil || errors.Is(err, os.ErrExist) {
+		return err
+	}
+	// No hard links (e.g. FAT): create it in place. Others leave a lock file
+	// they can't parse alone until it is older than lockStaleAfter.
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(l.info)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(l.path)
+	}
+	return err
+}
+
+// replace atomically overwrites the lock file with the current info.
+func (l *rootLock) replace() error {
+	tmp, err := l.writeTemp()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeTemp writes the lock info to a new file next to the lock file, so the
+// lock file itself is never seen partly written.
+func (l *rootLock) writeTemp() (string, error) {
+	b, err := json.Marshal(l.info)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(l.path), lockFileName+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// removeStale removes the lock file found stale as st. It is renamed away
+// first, so of several runs taking it over at once only one removes it; if
+// the renamed file turns out not to be st (the holder's heartbeat replaced
+// it, or another run already took over), it is put back.
+func (l *rootLock) removeStale(st os.FileInfo) error {
+	grave := fmt.Sprintf("%s.%d-%d.stale", l.path, l.info.PID, time.Now().UnixNano())
+	if err := os.Rename(l.path, grave); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("remove stale lock file: %w", err)
+	}
+	defer os.Remove(grave)
+	if cur, err := os.Stat(grave); err == nil && !os.SameFile(cur, st) {
+		_ = os.Link(grave, l.path)
+	}
+	return nil
+}
+
+func readLockInfo(path string) (lockInfo, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return lockInfo{}, err
+	}
+	var info lockInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return lockInfo{}, err
+	}
+	return info, nil
+}
+
+// sameRun reports whether l and o were written by the same run.
+func (l lockInfo) sameRun(o lockInfo) bool {
+	return l.PID == o.PID && l.Host == o.Host && l.Started.Equal(o.Started)
+}
+
+func (l lockInfo) stale(host string) bool {
+	if time.Since(l.Heartbeat) > lockStaleAfter {
+		return true
+	}
+	return l.Host == host && !processAlive(l.PID)
+}
+
+// heartbeat rewrites the lock file every lockHeartbeat until release, or
+// until it finds the lock taken over.
+func (l *rootLock) heartbeat(logf Logf) {
+	defer close(l.done)
+	t := time.NewTicker(lockHeartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-t.C:
+			cur, err := readLockInfo(l.path)
+			if errors.Is(err, os.ErrNotExist) || (err == nil && !cur.sameRun(l.info)) {
+				logf("Lost the lock %s (taken over as stale); another run may upload the same root\n", l.path)
+				return
+			}
+			l.info.Heartbeat = now
+			if err == nil {
+				err = l.replace()
+			}
+			if err != nil {
+				logf("lock heartbeat: %v\n", err)
+			}
+		}
+	}
+}
+
+// release stops the heartbeat and removes the lock file if it is still ours.
+func (l *rootLock) release() {
+	close(l.stop)
+	<-l.done
+	if cur, err := readLockInfo(l.path); err != nil || !cur.sameRun(l.info) {
+		return // taken over after being considered stale
+	}
+	_ = os.Remove(l.path)
+}
@@ -0,0 +1,119 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeLock(t *testing.T, root string, info lockInfo) string {
+	t.Helper()
+	b, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, lockFileName)
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRootLock(t *testing.T) {
+	root := t.TempDir()
+	ctx := context.Background()
+	l, err := acquireRootLock(ctx, root, 0, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquireRootLock(ctx, root, 0, t.Logf); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second lock: %v, want ErrLocked", err)
+	}
+	l.release()
+	if _, err := os.Stat(filepath.Join(root, lockFileName)); !os.IsNotExist(err) {
+		t.Errorf("lock file left after release: %v", err)
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Errorf("files left in root: %v", entries)
+	}
+}
+
+func TestRootLockStale(t *testing.T) {
+	host, _ := os.Hostname()
+	old := time.Now().Add(-2 * lockStaleAfter)
+
+	// An expired heartbeat, and a process that is gone, are taken over.
+	for name, info := range map[string]lockInfo{
+		"expired": {PID: 1, Host: "elsewhere", Started: old, Heartbeat: old},
+		"gone":    {PID: 1 << 30, Host: host, Started: time.Now(), Heartbeat: time.Now()},
+	} {
+		root := t.TempDir()
+		path := writeLock(t, root, info)
+		l, err := acquireRootLock(context.Background(), root, 0, t.Logf)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cur, err := readLockInfo(path); err != nil || !cur.sameRun(l.info) {
+			t.Errorf("%s: lock file = %+v, %v; want ours", name, cur, err)
+		}
+		l.release()
+	}
+
+	// A live holder on another host is not.
+	root := t.TempDir()
+	writeLock(t, root, lockInfo{PID: 1, Host: "elsewhere", Started: time.Now(), Heartbeat: time.Now()})
+	if _, err := acquireRootLock(context.Background(), root, 0, t.Logf); !errors.Is(err, ErrLocked) {
+		t.Errorf("live holder: %v, want ErrLocked", err)
+	}
+}
+
+func TestRootLockUnreadable(t *testing.T) {
+	// An empty lock file is another run's being written: left alone until it
+	// is older than lockStaleAfter.
+	root := t.TempDir()
+	path := filepath.Join(root, lockFileName)
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquireRootLock(context.Background(), root, 0, t.Logf); !errors.Is(err, ErrLocked) {
+		t.Fatalf("fresh empty lock: %v, want ErrLocked", err)
+	}
+	old := time.Now().Add(-2 * lockStaleAfter)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	l, err := acquireRootLock(context.Background(), root, 0, t.Logf)
+	if err != nil {
+		t.Fatalf("old empty lock: %v", err)
+	}
+	l.release()
+}
+
+func TestRemoveStaleKeepsReplacedLock(t *testing.T) {
+	// The holder's heartbeat replaced the file after it was judged stale:
+	// taking it over must not remove the new one.
+	root := t.TempDir()
+	path := writeLock(t, root, lockInfo{PID: 1, Host: "elsewhere"})
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := &rootLock{path: path, info: lockInfo{PID: 2, Host: "elsewhere", Started: time.Now(), Heartbeat: time.Now()}}
+	if err := live.replace(); err != nil {
+		t.Fatal(err)
+	}
+	l := &rootLock{path: path, info: lockInfo{PID: 3}}
+	if err := l.removeStale(st); err != nil {
+		t.Fatal(err)
+	}
+	if cur, err := readLockInfo(path); err != nil || !cur.sameRun(live.info) {
+		t.Errorf("lock file = %+v, %v; want the live holder's", cur, err)
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 1 {
+		t.Errorf("root has %d entries, want only the lock file", len(entries))
+	}
+}
@@ -0,0 +1,297 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"immich-uploader/immich"
+)
+
+// uploadJob carries one file through the pipeline stages:
+//
+//	hash -> preflight -> upload -> move
+//
+// Each stage fills in its part; a stage skips jobs that already failed.
+type uploadJob struct {
+	idx   int
+	file  fileEntry
+	album *albumState
+
+	sum     string              // hash
+	asset   immich.UploadResult // preflight (duplicate) or upload
+	dur     time.Duration       // upload
+	err     error
+	moveErr error
+	skipped string // reason the file was left alone for now (not an error)
+}
+
+// pipeline runs the stages with independent worker pools connected by bounded
+// channels, so hashing can run ahead of uploads and the network stays busy.
+type pipeline struct {
+	c        *client
+	opt      Options
+	cache    *checksumCache
+	deviceID string
+	breaker  *breaker
+	space    *spaceBudget // nil without a space check
+
+	// full is the first upload error saying the server is out of space.
+	// Once it is set, the remaining files are skipped rather than failed.
+	full atomic.Pointer[immich.StatusError]
+}
+
+const preflightBatchSize = 100
+
+func (p *pipeline) queueDepth() int {
+	n := 4 * max(p.opt.Workers, 1)
+	if n < 16 {
+		n = 16
+	}
+	return n
+}
+
+// run starts the stages and returns the channel of finished jobs. The returned
+// channel is closed once in is closed and every job has passed all stages.
+func (p *pipeline) run(ctx context.Context, in <-chan *uploadJob) <-chan *uploadJob {
+	depth := p.queueDepth()
+
+	hashed := make(chan *uploadJob, depth)
+	runStage(max(p.opt.HashWorkers, 1), in, hashed, func(j *uploadJob) { p.hash(j) })
+
+	checked := hashed
+	if p.opt.Checksum && !p.opt.NoPreflight {
+		checked = make(chan *uploadJob, depth)
+		p.runPreflight(ctx, max(p.opt.PreflightWorkers, 1), hashed, checked)
+	}
+
+	uploaded := make(chan *uploadJob, depth)
+	runStage(max(p.opt.Workers, 1), checked, uploaded, func(j *uploadJob) { p.upload(ctx, j) })
+
+	done := make(chan *uploadJob, depth)
+	runStage(1, uploaded, done, func(j *uploadJob) { p.move(ctx, j) })
+	return done
+}
+
+// runStage starts n workers applying fn to every job from in that hasn't
+// failed or been skipped yet, and closes out when in is drained.
+func runStage(n int, in <-chan *uploadJob, out chan<- *uploadJob, fn func(*uploadJob)) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range in {
+				if j.err == nil && j.skipped == "" {
+					fn(j)
+				}
+				out <- j
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+}
+
+// refreshSpace asks the server again how much it has room for and lets
+// uploads start again after it was full. Watch mode calls it before every
+// batch of settled files: space may have been freed (or used up) meanwhile.
+func (p *pipeline) refreshSpace(ctx context.Context) {
+	p.full.Store(nil)
+	if p.space != nil {
+		p.space.refresh(p.c.space(ctx))
+	}
+}
+
+// serverFull marks j as skipped once the server has run out of space.
+func (p *pipeline) serverFull(j *uploadJob) bool {
+	if p.full.Load() == nil {
+		return false
+	}
+	j.skipped = skipServerFull
+	return true
+}
+
+func (p *pipeline) hash(j *uploadJob) {
+	if p.serverFull(j) {
+		return
+	}
+	// Don't spend time hashing (or uploading) files that are still being written.
+	// A negative age means an mtime in the future (clock skew), not a writer.
+	if age := time.Since(j.file.modTime); p.opt.SettleTime > 0 && age >= 0 && age < p.opt.SettleTime {
+		j.skipped = fmt.Sprintf("modified within the last %s", p.opt.SettleTime)
+		return
+	}
+	if !p.opt.Checksum {
+		return
+	}
+	var (
+		sum string
+		err error
+	)
+	if p.cache != nil {
+		sum, err = p.cache.checksum(j.file)
+	} else {
+		sum, err = sha1File(j.file.path)
+	}
+	if err == nil {
+		j.sum = sum
+	}
+}
+
+// runPreflight asks the server which hashed files it already has, in batches of
+// whatever is queued (up to preflightBatchSize), and marks those as duplicates.
+func (p *pipeline) runPreflight(ctx context.Context, n int, in <-chan *uploadJob, out chan<- *uploadJob) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range in {
+				batch := []*uploadJob{j}
+			drain:
+				for len(batch) < preflightBatchSize {
+					select {
+					case next, ok := <-in:
+						if !ok {
+							break drain
+						}
+						batch = append(batch, next)
+					default:
+						break drain
+					}
+				}
+				p.preflight(ctx, batch)
+				for _, b := range batch {
+					out <- b
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+}
+
+func (p *pipeline) preflight(ctx context.Context, batch []*uploadJob) {
+	items := make([]immich.UploadCheck, 0, len(batch))
+	for i, j := range batch {
+		if j.err != nil || j.skipped != "" || j.sum == "" {
+			continue
+		}
+		items = append(items, immich.UploadCheck{ID: strconv.Itoa(i), Checksum: j.sum})
+	}
+	if len(items) == 0 {
+		return
+	}
+	if p.breaker.wait(ctx) != nil {
+		return
+	}
+	results, err := p.c.BulkUploadCheck(ctx, items)
+	p.breaker.observe(ctx, err)
+	if err != nil {
+		// Not fatal: the upload itself still dedupes via x-immich-checksum.
+		return
+	}
+	for _, r := range results {
+		i, err := strconv.Atoi(r.ID)
+		if err != nil || i < 0 || i >= len(batch) || r.Action != "reject" {
+			continue
+		}
+		j := batch[i]
+		switch r.Reason {
+		case "duplicate":
+			j.asset = immich.UploadResult{ID: r.AssetID, Status: immich.StatusDuplicate}
+		case "unsupported-format":
+			j.err = fmt.Errorf("rejected by server: unsupported format")
+		}
+	}
+}
+
+func (p *pipeline) upload(ctx context.Context, j *uploadJob) {
+	if j.asset.ID != "" || p.serverFull(j) {
+		return
+	}
+	f := j.file
+	if changed, err := fileChanged(f); err != nil {
+		j.err = err
+		return
+	} else if changed {
+		j.skipped = "changed since it was scanned"
+		return
+	}
+	if !p.space.reserve(f.size) {
+		j.skipped = skipServerFull
+		return
+	}
+	stored := false
+	defer func() { p.space.finish(f.size, stored) }()
+	rel, _ := filepath.Rel(p.opt.Root, f.path)
+	deviceAssetID := sha1HexString(rel)
+
+	var (
+		asset immich.UploadResult
+		err   error
+	)
+	for try := 0; ; try++ {
+		if p.breaker.wait(ctx) != nil {
+			j.skipped = skipAborted
+			return
+		}
+		start := time.Now()
+		asset, err = p.c.uploadAsset(ctx, f.path, p.deviceID, deviceAssetID, f.modTime, f.modTime, j.sum)
+		j.dur = time.Since(start)
+		if !p.breaker.observeOne(ctx, err) || try == breakerRetries {
+			break
+		}
+	}
+	var se *immich.StatusError
+	isStatus := errors.As(err, &se)
+	if err != nil && ctx.Err() != nil && !isStatus {
+		j.skipped = skipAborted // interrupted before the server answered
+		return
+	}
+	j.asset = asset
+	j.err = err
+	stored = err == nil && !isDuplicate(asset)
+	if err != nil {
+		if isStatus && isOutOfSpace(err) {
+			p.full.CompareAndSwap(nil, se)
+		}
+		return
+	}
+	// The file must not be moved away if a writer was still appending to it:
+	// what the server got may be truncated.
+	if changed, _ := fileChanged(f); changed {
+		j.err = fmt.Errorf("file changed during upload (asset %s may be incomplete); left in place", asset.ID)
+	}
+}
+
+// fileChanged reports whether the size or mtime of e differ from the file on disk.
+func fileChanged(e fileEntry) (bool, error) {
+	st, err := os.Stat(e.path)
+	if err != nil {
+		return false, err
+	}
+	return st.Size() != e.size || !st.ModTime().Equal(e.modTime), nil
+}
+
+// move takes a file that is on the server out of the root, or runs
+// Hooks.AfterUpload instead.
+func (p *pipeline) move(ctx context.Context, j *uploadJob) {
+	f := j.file
+	if after := p.opt.Hooks.AfterUpload; after != nil {
+		j.moveErr = after(ctx, f.info(), j.asset)
+		return
+	}
+	j.moveErr = moveFileToIgnore(p.opt.Root, p.opt.IgnoreDir, f.album, f.albumPath, f.path)
+}
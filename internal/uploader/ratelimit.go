@@ -0,0 +1,215 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by all upload workers. The bucket holds
+// at most one second worth of tokens at the current rate.
+type rateLimiter struct {
+	limit    int64 // bytes/s outside scheduled windows (<= 0 = unlimited)
+	schedule []rateWindow
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// rateWindow applies a different limit between two times of day. Windows may
+// wrap midnight (e.g. 22:00-06:00); one that ends when it starts (e.g.
+// 00:00-00:00) lasts all day.
+type rateWindow struct {
+	from, to time.Duration // offsets since local midnight
+	limit    int64
+}
+
+func newRateLimiter(limit int64, schedule []rateWindow) *rateLimiter {
+	if limit <= 0 && len(schedule) == 0 {
+		return nil
+	}
+	return &rateLimiter{limit: limit, schedule: schedule, now: time.Now}
+}
+
+// current returns the limit in effect at t.
+func (l *rateLimiter) current(t time.Time) int64 {
+	y, m, d := t.Date()
+	tod := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	for _, w := range l.schedule {
+		if w.from == w.to {
+			return w.limit
+		}
+		if w.from < w.to {
+			if tod >= w.from && tod < w.to {
+				return w.limit
+			}
+		} else if tod >= w.from || tod < w.to {
+			return w.limit
+		}
+	}
+	return l.limit
+}
+
+// lowest returns the lowest limit in effect at any time of day, or 0 if
+// uploads are never limited.
+func (l *rateLimiter) lowest() int64 {
+	low := l.limit
+	for _, w := range l.schedule {
+		if w.limit > 0 && (low <= 0 || w.limit < low) {
+			low = w.limit
+		}
+	}
+	return max(low, 0)
+}
+
+// wait blocks until n bytes may be sent.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	for {
+		l.mu.Lock()
+		now := l.now()
+		rate := l.current(now)
+		if rate <= 0 {
+			l.last = now
+			l.mu.Unlock()
+			return nil
+		}
+		if !l.last.IsZero() {
+			l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+		}
+		l.last = now
+		if l.tokens > float64(rate) {
+			l.tokens = float64(rate)
+		}
+		if l.tokens >= float64(n) {
+			l.tokens -= float64(n)
+			l.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((float64(n) - l.tokens) / float64(rate) * float64(time.Second))
+		l.mu.Unlock()
+
+		// re-check at least every second so schedule changes take effect promptly
+		if delay > time.Second {
+			delay = time.Second
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// chunkSize returns how many bytes to request at once so a single write never
+// asks for more than the bucket can hold.
+func (l *rateLimiter) chunkSize() int {
+	const maxChunk = 32 * 1024
+	rate := l.current(l.now())
+	if rate <= 0 || rate >= maxChunk {
+		return maxChunk
+	}
+	return int(rate)
+}
+
+// rateLimitedReader reads at most one chunk at a time and holds it back
+// until the limiter has that many bytes to spare.
+type rateLimitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if c := r.l.chunkSize(); len(p) > c {
+		p = p[:c]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.wait(r.ctx, n); werr != nil {
+			return 0, werr
+		}
+	}
+	return n, err
+}
+
+// ParseByteSize parses sizes like "500K", "2MiB", "1.5MB" or "1048576".
+// K/M/G and their KB/KiB variants are all treated as powers of 1024.
+func ParseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	upper := strings.ToUpper(s)
+	upper = strings.TrimSuffix(upper, "/S")
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper = strings.TrimSuffix(upper, u.suffix)
+			mult = u.mult
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// parseRateSchedule parses "HH:MM-HH:MM=RATE[,...]", e.g. "08:00-23:00=1MiB".
+// A rate of 0 means unlimited during that window; equal times mean all day.
+func parseRateSchedule(s string) ([]rateWindow, error) {
+	var out []rateWindow
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		span, rate, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule entry %q (want HH:MM-HH:MM=RATE)", part)
+		}
+		fromS, toS, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule entry %q (want HH:MM-HH:MM=RATE)", part)
+		}
+		from, err := parseTimeOfDay(fromS)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseTimeOfDay(toS)
+		if err != nil {
+			return nil, err
+		}
+		limit, err := ParseByteSize(rate)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rateWindow{from: from, to: to, limit: limit})
+	}
+	return out, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (want HH:MM)", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
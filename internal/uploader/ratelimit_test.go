@@ -0,0 +1,95 @@
+package uploader
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for s, want := range map[string]int64{
+		"":        0,
+		"0":       0,
+		"1048576": 1 << 20,
+		"500K":    500 << 10,
+		"500kb":   500 << 10,
+		"2MiB":    2 << 20,
+		"1.5MB":   3 << 19,
+		"1G":      1 << 30,
+		" 3 MiB ": 3 << 20,
+		"2MiB/s":  2 << 20,
+		"100B":    100,
+		"1024GiB": 1 << 40,
+	} {
+		if got, err := ParseByteSize(s); err != nil || got != want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"fast", "-1M", "1T", "M"} {
+		if _, err := ParseByteSize(s); err == nil {
+			t.Errorf("ParseByteSize(%q) succeeded", s)
+		}
+	}
+}
+
+func TestParseRateSchedule(t *testing.T) {
+	h := time.Hour
+	for s, want := range map[string][]rateWindow{
+		"":                                nil,
+		"08:00-23:00=1MiB":                {{8 * h, 23 * h, 1 << 20}},
+		"23:00-08:00=0, 08:00-23:00=500K": {{23 * h, 8 * h, 0}, {8 * h, 23 * h, 500 << 10}},
+		"00:00-00:00=1MiB":                {{0, 0, 1 << 20}},
+		"12:30-12:45=1K,":                 {{12*h + 30*time.Minute, 12*h + 45*time.Minute, 1 << 10}},
+	} {
+		got, err := parseRateSchedule(s)
+		if err != nil || !slices.Equal(got, want) {
+			t.Errorf("parseRateSchedule(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"08:00-23:00", "08:00=1M", "8-23=1M", "25:00-01:00=1M", "08:00-23:00=fast"} {
+		if _, err := parseRateSchedule(s); err == nil {
+			t.Errorf("parseRateSchedule(%q) succeeded", s)
+		}
+	}
+}
+
+func TestRateLimiterCurrent(t *testing.T) {
+	at := func(hhmm string) time.Time {
+		tod, err := time.Parse("15:04", hhmm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Date(2026, 3, 14, tod.Hour(), tod.Minute(), 0, 0, time.Local)
+	}
+	for _, tc := range []struct {
+		schedule string
+		limit    int64
+		want     map[string]int64 // time of day -> limit in effect
+	}{
+		{"08:00-23:00=1K", 5, map[string]int64{
+			"07:59": 5, "08:00": 1 << 10, "22:59": 1 << 10, "23:00": 5, "00:00": 5,
+		}},
+		// wraps midnight
+		{"22:00-06:00=2K", 0, map[string]int64{
+			"21:59": 0, "22:00": 2 << 10, "23:59": 2 << 10, "00:00": 2 << 10, "05:59": 2 << 10, "06:00": 0,
+		}},
+		// unlimited at night, capped otherwise; the first window wins
+		{"23:00-07:00=0,00:00-00:00=1K", 5, map[string]int64{
+			"23:30": 0, "03:00": 0, "07:00": 1 << 10, "12:00": 1 << 10,
+		}},
+		// all day
+		{"00:00-00:00=3K", 5, map[string]int64{"00:00": 3 << 10, "12:00": 3 << 10, "23:59": 3 << 10}},
+		{"09:30-09:30=3K", 5, map[string]int64{"09:29": 3 << 10, "09:30": 3 << 10}},
+	} {
+		schedule, err := parseRateSchedule(tc.schedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := newRateLimiter(tc.limit, schedule)
+		for tod, want := range tc.want {
+			if got := l.current(at(tod)); got != want {
+				t.Errorf("%s at %s: limit %d, want %d", tc.schedule, tod, got, want)
+			}
+		}
+	}
+}
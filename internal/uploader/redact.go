@@ -0,0 +1,18 @@
+package uploader
+
+import (
+	"fmt"
+
+	"immich-uploader/immich"
+)
+
+// redactLogf wraps logf so formatted messages never contain the secrets.
+func redactLogf(logf Logf, secrets ...string) Logf {
+	return func(format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		if immich.ContainsSecret(msg, secrets...) {
+			msg = immich.Redact(msg, secrets...)
+		}
+		logf("%s", msg)
+	}
+}
@@ -0,0 +1,302 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileEntry is the metadata of one media file, collected once by the scanner
+// and passed around by value afterwards.
+type fileEntry struct {
+	path      string
+	album     string
+	albumPath string
+	size      int64
+	modTime   time.Time
+	mode      fs.FileMode
+	sys       any
+}
+
+func (e fileEntry) inode() uint64 {
+	return fileInode(e.path, e.sys)
+}
+
+type scanEventKind int
+
+const (
+	scanAlbumStart scanEventKind = iota
+	scanFile
+	scanAlbumEnd
+	scanBatch // watch mode: a batch of settled files follows
+)
+
+// scanEvent is emitted by the scanner. Events of one album are contiguous:
+// scanAlbumStart, zero or more scanFile, then scanAlbumEnd.
+type scanEvent struct {
+	kind      scanEventKind
+	album     string
+	albumPath string
+
+	// scanFile
+	file fileEntry
+
+	// scanAlbumEnd
+	files int
+	bytes int64
+	err   error
+}
+
+type scanner struct {
+	root          string
+	ignoreDir     string
+	deep          bool
+	smallestFirst bool
+	// readers is the number of directories read concurrently within an album.
+	// With 1 (or less) the album is walked sequentially in lexical order.
+	readers int
+	filter  func(FileInfo) bool // Hooks.Filter
+}
+
+func newScanner(opt Options) *scanner {
+	return &scanner{
+		root:          opt.Root,
+		ignoreDir:     opt.IgnoreDir,
+		deep:          opt.Deep,
+		smallestFirst: opt.SmallestFirst,
+		readers:       opt.ScanReaders,
+		filter:        opt.Hooks.Filter,
+	}
+}
+
+// events implements eventSource for a one-shot run.
+func (s *scanner) events(ctx context.Context, _ Logf) (<-chan scanEvent, error) {
+	ch, err := s.scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read root dir: %w", err)
+	}
+	return ch, nil
+}
+
+func (s *scanner) release(string) {}
+
+// scan lists the album folders under the root and streams their media files.
+// The returned channel is closed once every album has been walked or ctx is done.
+func (s *scanner) scan(ctx context.Context) (<-chan scanEvent, error) {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan scanEvent)
+	go func() {
+		defer close(out)
+		for _, e := range entries {
+			if !e.IsDir() || e.Name() == s.ignoreDir {
+				continue
+			}
+			name := e.Name()
+			path := filepath.Join(s.root, name)
+			if !s.send(ctx, out, scanEvent{kind: scanAlbumStart, album: name, albumPath: path}) {
+				return
+			}
+			files, bytes, err := s.scanAlbum(ctx, name, path, out)
+			if ctx.Err() != nil {
+				return
+			}
+			if !s.send(ctx, out, scanEvent{kind: scanAlbumEnd, album: name, albumPath: path, files: files, bytes: bytes, err: err}) {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *scanner) send(ctx context.Context, out chan<- scanEvent, ev scanEvent) bool {
+	select {
+	case out <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *scanner) scanAlbum(ctx context.Context, album, albumPath string, out chan<- scanEvent) (int, int64, error) {
+	var (
+		mu       sync.Mutex
+		files    int
+		bytes    int64
+		buffered []fileEntry
+	)
+	emit := func(e fileEntry) bool {
+		mu.Lock()
+		files++
+		bytes += e.size
+		if s.smallestFirst {
+			buffered = append(buffered, e)
+			mu.Unlock()
+			return true
+		}
+		mu.Unlock()
+		return s.send(ctx, out, scanEvent{kind: scanFile, album: album, albumPath: albumPath, file: e})
+	}
+
+	var err error
+	if s.readers > 1 {
+		err = s.walkParallel(ctx, album, albumPath, emit)
+	} else {
+		err = s.walk(ctx, album, albumPath, emit)
+	}
+	if err != nil {
+		return files, bytes, err
+	}
+
+	if s.smallestFirst {
+		sort.Slice(buffered, func(i, j int) bool {
+			if buffered[i].size == buffered[j].size {
+				return buffered[i].path < buffered[j].path
+			}
+			return buffered[i].size < buffered[j].size
+		})
+		for _, e := range buffered {
+			if !s.send(ctx, out, scanEvent{kind: scanFile, album: album, albumPath: albumPath, file: e}) {
+				return files, bytes, ctx.Err()
+			}
+		}
+	}
+	return files, bytes, nil
+}
+
+func (s *scanner) walk(ctx context.Context, album, albumPath string, emit func(fileEntry) bool) error {
+	return filepath.WalkDir(albumPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != albumPath && !s.deep {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		e, ok, err := s.entry(album, albumPath, path, d)
+		if err != nil || !ok {
+			return err
+		}
+		if !emit(e) {
+			return ctx.Err()
+		}
+		return nil
+	})
+}
+
+// walkParallel reads up to s.readers directories of the album tree at once.
+// The first error stops the walk, like filepath.WalkDir.
+func (s *scanner) walkParallel(ctx context.Context, album, albumPath string, emit func(fileEntry) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, s.readers)
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	var readDir func(dir string)
+	readDir = func(dir string) {
+		defer wg.Done()
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		entries, err := os.ReadDir(dir)
+		<-sem
+		if err != nil {
+			fail(err)
+			return
+		}
+		for _, d := range entries {
+			path := filepath.Join(dir, d.Name())
+			if d.IsDir() {
+				if s.deep {
+					wg.Add(1)
+					go readDir(path)
+				}
+				continue
+			}
+			e, ok, err := s.entry(album, albumPath, path, d)
+			if err != nil {
+				fail(err)
+				return
+			}
+			if ok && !emit(e) {
+				fail(ctx.Err())
+				return
+			}
+		}
+	}
+
+	wg.Add(1)
+	go readDir(albumPath)
+	wg.Wait()
+	if firstErr == nil {
+		return ctx.Err()
+	}
+	return firstErr
+}
+
+// entry builds the fileEntry for a directory entry, or reports ok=false for
+// files that should not be uploaded.
+func (s *scanner) entry(album, albumPath, path string, d os.DirEntry) (fileEntry, bool, error) {
+	name := d.Name()
+	if strings.HasPrefix(name, ".") || (s.filter == nil && !IsMediaFile(name)) {
+		return fileEntry{}, false, nil
+	}
+	var (
+		st  os.FileInfo
+		err error
+	)
+	if d.Type()&fs.ModeSymlink != 0 {
+		st, err = os.Stat(path)
+	} else {
+		st, err = d.Info()
+	}
+	if err != nil {
+		return fileEntry{}, false, err
+	}
+	if !st.Mode().IsRegular() {
+		return fileEntry{}, false, nil
+	}
+	e := newFileEntry(album, albumPath, path, st)
+	return e, s.wants(e), nil
+}
+
+// wants applies Hooks.Filter; without it, the extension was checked already.
+func (s *scanner) wants(e fileEntry) bool {
+	return s.filter == nil || s.filter(e.info())
+}
+
+func newFileEntry(album, albumPath, path string, st os.FileInfo) fileEntry {
+	return fileEntry{
+		path:      path,
+		album:     album,
+		albumPath: albumPath,
+		size:      st.Size(),
+		modTime:   st.ModTime(),
+		mode:      st.Mode(),
+		sys:       st.Sys(),
+	}
+}
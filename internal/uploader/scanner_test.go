@@ -0,0 +1,171 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// scanTree is the test tree: path -> size. Directories are created as needed;
+// a trailing slash is an empty directory.
+var scanTree = map[string]int{
+	"top.jpg":              3, // not in an album folder
+	"ignore/X/done.jpg":    3,
+	"A/a1.jpg":             5,
+	"A/a2.jpg":             20,
+	"A/.hidden.jpg":        7,
+	"A/notes.txt":          7,
+	"A/sub/s.jpg":          10,
+	"A/sub/deeper/d.jpg":   1,
+	"A/sub/deeper/.x.jpg":  1,
+	"B/b.mp4":              3,
+	"C/":                   0,
+	"C/empty/":             0,
+	"C/empty/not-media.md": 2,
+}
+
+func writeScanTree(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, size := range scanTree {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// scanEvents runs the scanner and renders its events as lines. With sorted,
+// the files of each album are sorted by path, for walks whose order is not
+// defined.
+func scanEvents(t *testing.T, opt Options, sorted bool) []string {
+	t.Helper()
+	events, err := newScanner(opt).scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out, files []string
+	for ev := range events {
+		switch ev.kind {
+		case scanAlbumStart:
+			if ev.albumPath != filepath.Join(opt.Root, ev.album) {
+				t.Errorf("album %s: path %s", ev.album, ev.albumPath)
+			}
+			out = append(out, "start "+ev.album)
+		case scanFile:
+			e := ev.file
+			if e.album != ev.album || e.albumPath != ev.albumPath {
+				t.Errorf("%s: entry of album %s (%s) in album %s", e.path, e.album, e.albumPath, ev.album)
+			}
+			rel, _ := filepath.Rel(opt.Root, e.path)
+			files = append(files, fmt.Sprintf("file %s %d", filepath.ToSlash(rel), e.size))
+		case scanAlbumEnd:
+			if sorted {
+				slices.Sort(files)
+			}
+			out = append(out, files...)
+			files = nil
+			out = append(out, fmt.Sprintf("end %s %d %d %v", ev.album, ev.files, ev.bytes, ev.err))
+		}
+	}
+	return out
+}
+
+func TestScanner(t *testing.T) {
+	root := writeScanTree(t)
+	for _, tc := range []struct {
+		name          string
+		deep          bool
+		smallestFirst bool
+		want          []string
+	}{
+		{"deep", true, false, []string{
+			"start A",
+			"file A/a1.jpg 5",
+			"file A/a2.jpg 20",
+			"file A/sub/deeper/d.jpg 1",
+			"file A/sub/s.jpg 10",
+			"end A 4 36 <nil>",
+			"start B", "file B/b.mp4 3", "end B 1 3 <nil>",
+			"start C", "end C 0 0 <nil>",
+		}},
+		{"deep smallest first", true, true, []string{
+			"start A",
+			"file A/sub/deeper/d.jpg 1",
+			"file A/a1.jpg 5",
+			"file A/sub/s.jpg 10",
+			"file A/a2.jpg 20",
+			"end A 4 36 <nil>",
+			"start B", "file B/b.mp4 3", "end B 1 3 <nil>",
+			"start C", "end C 0 0 <nil>",
+		}},
+		{"flat", false, false, []string{
+			"start A", "file A/a1.jpg 5", "file A/a2.jpg 20", "end A 2 25 <nil>",
+			"start B", "file B/b.mp4 3", "end B 1 3 <nil>",
+			"start C", "end C 0 0 <nil>",
+		}},
+	} {
+		for _, readers := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s/readers=%d", tc.name, readers), func(t *testing.T) {
+				opt := Options{Root: root, IgnoreDir: "ignore", Deep: tc.deep, SmallestFirst: tc.smallestFirst, ScanReaders: readers}
+				// Parallel readers find files in no particular order;
+				// only smallest-first sorts them.
+				sorted := readers > 1 && !tc.smallestFirst
+				want := tc.want
+				if sorted {
+					want = scanEvents(t, Options{Root: root, IgnoreDir: "ignore", Deep: tc.deep, ScanReaders: 1}, true)
+				}
+				if got := scanEvents(t, opt, sorted); !slices.Equal(got, want) {
+					t.Errorf("events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+				}
+			})
+		}
+	}
+}
+
+func TestScannerFilter(t *testing.T) {
+	root := writeScanTree(t)
+	opt := Options{Root: root, IgnoreDir: "ignore", Deep: true, ScanReaders: 1}
+	opt.Hooks.Filter = func(f FileInfo) bool {
+		return strings.HasSuffix(f.Path, ".txt") || strings.HasSuffix(f.Path, ".mp4")
+	}
+	want := []string{
+		"start A", "file A/notes.txt 7", "end A 1 7 <nil>",
+		"start B", "file B/b.mp4 3", "end B 1 3 <nil>",
+		"start C", "end C 0 0 <nil>",
+	}
+	if got := scanEvents(t, opt, false); !slices.Equal(got, want) {
+		t.Errorf("events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestScannerCancel checks that the event channel is closed once ctx is done,
+// even though nobody reads the rest of the album.
+func TestScannerCancel(t *testing.T) {
+	root := writeScanTree(t)
+	for _, readers := range []int{1, 4} {
+		ctx, cancel := context.WithCancel(context.Background())
+		events, err := newScanner(Options{Root: root, IgnoreDir: "ignore", Deep: true, ScanReaders: readers}).scan(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-events // album A starts
+		cancel()
+		for range events {
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package uploader
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"immich-uploader/immich"
+)
+
+// albumState tracks one album folder while its files are in flight in the
+// run-wide pipeline. An album is finalized (assets added to the Immich album,
+// ignore folder cleaned up) once the scanner has finished listing it and every
+// queued file has come back out of the pipeline.
+type albumState struct {
+	name  string // album folder
+	album string // Immich album
+	path  string
+	id    string
+	start time.Time
+
+	// guarded by albumTracker.mu
+	queued      int
+	queuedBytes int64
+	done        int
+	doneBytes   int64
+	ended       bool
+	finalized   bool
+	walkErr     error
+	assetIDs    []string
+	errors      int
+	skipped     int
+	moved       int
+}
+
+type albumTracker struct {
+	mu sync.Mutex
+}
+
+// ready reports whether a can be finalized and marks it as such, so exactly
+// one caller gets true.
+func (t *albumTracker) ready(a *albumState) bool {
+	if a.finalized || !a.ended || a.done < a.queued {
+		return false
+	}
+	a.finalized = true
+	return true
+}
+
+func (t *albumTracker) queue(a *albumState, f fileEntry) {
+	t.mu.Lock()
+	a.queued++
+	a.queuedBytes += f.size
+	t.mu.Unlock()
+}
+
+// end records that the scanner has listed every file of a.
+func (t *albumTracker) end(a *albumState, walkErr error) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a.ended = true
+	a.walkErr = walkErr
+	return t.ready(a)
+}
+
+// complete records a finished job and reports whether its album is now done.
+func (t *albumTracker) complete(j *uploadJob, dedupeAdd bool) bool {
+	a := j.album
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a.done++
+	a.doneBytes += j.file.size
+	if j.err != nil {
+		a.errors++
+		return t.ready(a)
+	}
+	if j.skipped != "" {
+		a.skipped++
+		return t.ready(a)
+	}
+	if j.moveErr == nil {
+		a.moved++
+	}
+	if !isDuplicate(j.asset) || dedupeAdd {
+		a.assetIDs = append(a.assetIDs, j.asset.ID)
+	}
+	return t.ready(a)
+}
+
+func isDuplicate(a immich.UploadResult) bool {
+	return strings.Contains(strings.ToLower(a.Status), "duplicate")
+}
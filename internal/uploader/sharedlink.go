@@ -0,0 +1,34 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"immich-uploader/immich"
+)
+
+// Shared link mode (upload into someone else's album):
+// - GET /shared-links/me?key=...  (SharedLinkResponseDto)
+// Every request carries the link's key (or slug) as a query parameter instead
+// of an API key (see immich.SharedLink). Albums cannot be listed or created;
+// every album folder is uploaded into the link's album.
+
+// openSharedLink checks that the link accepts uploads into an album and
+// returns that album.
+func (c *client) openSharedLink(ctx context.Context) (immich.Album, error) {
+	link, err := c.OpenSharedLink(ctx)
+	if err != nil {
+		return immich.Album{}, fmt.Errorf("open shared link: %w", err)
+	}
+	switch {
+	case link.ExpiresAt != nil && link.ExpiresAt.Before(time.Now()):
+		return immich.Album{}, fmt.Errorf("shared link expired on %s", link.ExpiresAt.Local().Format(time.DateTime))
+	case !link.AllowUpload:
+		return immich.Album{}, errors.New("shared link does not allow uploads")
+	case link.Album == nil || link.Type != "ALBUM":
+		return immich.Album{}, errors.New("shared link is not an album link")
+	}
+	return *link.Album, nil
+}
@@ -0,0 +1,222 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"immich-uploader/immich"
+)
+
+// Storage checks (at the start of a run and of every watch batch, see
+// spaceBudget):
+// - GET /server/storage  (ServerStorageResponseDto) — free disk space
+// - GET /users/me        (UserAdminResponseDto) — quotaSizeInBytes, quotaUsageInBytes
+
+// ErrServerFull is returned when the space check stopped the uploads before
+// they exceeded the server's free space, or when the server ran out of space
+// (or quota) during the run.
+var ErrServerFull = errors.New("server is out of space")
+
+// Space check modes (Options.SpaceCheck).
+const (
+	SpaceCheckAbort = "abort" // default
+	SpaceCheckWarn  = "warn"
+	SpaceCheckOff   = "off"
+)
+
+// skipServerFull is the skip reason of files left in place after the server
+// reported that it is out of space.
+const skipServerFull = "server is out of space"
+
+// serverSpace is what the server has room for. -1 means unknown (or, for
+// the quota, unlimited); unknown says why.
+type serverSpace struct {
+	diskFree  int64
+	quotaLeft int64
+	unknown   []string
+}
+
+// limit is the most that can still be uploaded, or -1 if nothing is known.
+func (s serverSpace) limit() int64 {
+	switch {
+	case s.diskFree < 0:
+		return s.quotaLeft
+	case s.quotaLeft < 0:
+		return s.diskFree
+	default:
+		return min(s.diskFree, s.quotaLeft)
+	}
+}
+
+func (s serverSpace) String() string {
+	var parts []string
+	if s.diskFree >= 0 {
+		parts = append(parts, formatBytes(s.diskFree)+" free on disk")
+	}
+	if s.quotaLeft >= 0 {
+		parts = append(parts, formatBytes(s.quotaLeft)+" left in quota")
+	}
+	parts = append(parts, s.unknown...)
+	return strings.Join(parts, ", ")
+}
+
+// space asks the server for its free disk space and the user's remaining
+// quota. Neither is fatal: keys without server.storage, shared links and
+// unlimited quotas just leave that part unknown.
+func (c *client) space(ctx context.Context) serverSpace {
+	s := serverSpace{diskFree: -1, quotaLeft: -1}
+	if _, ok := c.Auth.(*immich.SharedLink); ok {
+		s.unknown = append(s.unknown, "free space is not visible to shared links")
+		return s
+	}
+
+	storage, err := c.Storage(ctx)
+	if err != nil {
+		if errors.Is(err, ErrAuth) || errors.Is(err, ErrPermission) {
+			s.unknown = append(s.unknown, "free disk space not readable (the API key needs server.storage)")
+		} else {
+			s.unknown = append(s.unknown, fmt.Sprintf("free disk space unknown (%v)", err))
+		}
+	} else if storage.DiskSizeRaw > 0 {
+		s.diskFree = storage.DiskAvailableRaw
+	}
+
+	me, err := c.Me(ctx)
+	if err != nil {
+		s.unknown = append(s.unknown, fmt.Sprintf("quota unknown (%v)", err))
+	} else if me.QuotaSizeInBytes != nil && *me.QuotaSizeInBytes > 0 {
+		used := int64(0)
+		if me.QuotaUsageInBytes != nil {
+			used = *me.QuotaUsageInBytes
+		}
+		s.quotaLeft = max(*me.QuotaSizeInBytes-used, 0)
+	}
+	return s
+}
+
+// spaceBudget counts the bytes of the uploads started in a run against what
+// the server had room for when it began (in watch mode, when the current batch
+// of settled files began). Only files that hashing and the duplicate preflight
+// left for upload count; without checksums (or with the preflight off)
+// duplicates can't be told apart and count too, so the total errs on the high
+// side.
+type spaceBudget struct {
+	mode string // SpaceCheckAbort or SpaceCheckWarn
+	logf Logf
+
+	mu         sync.Mutex
+	limit      int64 // -1 = unknown: nothing is checked
+	used       int64 // stored since the server was asked
+	inFlight   int64 // reserved by uploads that haven't finished
+	exceeded   bool  // abort: no more uploads start
+	warned     bool
+	nearWarned bool
+}
+
+// newSpaceBudget asks the server how much it has room for. mode is one of
+// the SpaceCheck constants; with SpaceCheckOff it returns nil.
+func (c *client) newSpaceBudget(ctx context.Context, mode string, logf Logf) *spaceBudget {
+	if mode == SpaceCheckOff {
+		return nil
+	}
+	b := &spaceBudget{mode: mode, logf: logf}
+	b.refresh(c.space(ctx))
+	return b
+}
+
+// refresh starts counting again against s, what the server has room for now.
+// The bytes already stored are part of s; the uploads still in flight keep
+// their reservations.
+func (b *spaceBudget) refresh(s serverSpace) {
+	if b == nil {
+		return
+	}
+	b.logf("Space check: server has %s\n", s)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limit, b.used = s.limit(), 0
+	b.exceeded, b.warned, b.nearWarned = false, false, false
+}
+
+// reserve counts an upload of size bytes and reports whether it may start.
+// With SpaceCheckAbort the first upload that doesn't fit, and every one after
+// it, is refused, so the rest of the files stay in place for a later run.
+// With SpaceCheckWarn it is logged once and the uploads go on until the server
+// reports that it is full.
+func (b *spaceBudget) reserve(size int64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.exceeded {
+		return false
+	}
+	total := b.used + b.inFlight + size
+	switch {
+	case b.limit < 0:
+	case total > b.limit:
+		if b.mode == SpaceCheckAbort {
+			b.exceeded = true
+			b.logf("The next upload would exceed the %s the server has room for: the remaining files are left in place (use --space-check=warn to upload until it is full)\n", formatBytes(b.limit))
+			return false
+		}
+		if !b.warned {
+			b.warned = true
+			b.logf("warning: the uploads now exceed the %s the server had room for; they stop once it is full\n", formatBytes(b.limit))
+		}
+	case total > b.limit/10*9 && !b.nearWarned:
+		b.nearWarned = true
+		b.logf("warning: the uploads leave less than 10%% of the server's free space (%s)\n", formatBytes(b.limit-total))
+	}
+	b.inFlight += size
+	return true
+}
+
+// finish ends the reservation of an upload of size bytes. stored is false
+// when it stored nothing new: it failed, or the server found a duplicate.
+func (b *spaceBudget) finish(size int64, stored bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inFlight -= size
+	if stored {
+		b.used += size
+	}
+}
+
+// isExceeded reports whether SpaceCheckAbort stopped the uploads.
+func (b *spaceBudget) isExceeded() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exceeded
+}
+
+// isOutOfSpace reports whether err is the server saying that its disk or the
+// user's quota is full. Immich has no dedicated status for this: a full
+// quota is a 400 and a full disk surfaces as a 500 with the ENOSPC message.
+func isOutOfSpace(err error) bool {
+	var se *immich.StatusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	if se.Code == http.StatusInsufficientStorage {
+		return true
+	}
+	body := strings.ToLower(se.Body)
+	for _, s := range []string{"quota has been exceeded", "enospc", "no space left on device"} {
+		if strings.Contains(body, s) {
+			return true
+		}
+	}
+	return false
+}
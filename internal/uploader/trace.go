@@ -0,0 +1,338 @@
+package uploader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"immich-uploader/immich"
+)
+
+// Tracing (--trace, --har): a RoundTripper under the client's transport
+// stack logs every request and response and records them for a HAR 1.2
+// file. Credentials are redacted from URLs, headers and bodies; bodies are
+// truncated and upload payloads are never captured.
+
+// traceBodyLimit is how much of a request or response body is kept.
+const traceBodyLimit = 4 << 10
+
+// sensitiveHeaders are always redacted, whatever their value.
+var sensitiveHeaders = []string{"X-Api-Key", "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// sensitiveJSON matches credential fields in JSON bodies (login, shared links).
+var sensitiveJSON = regexp.MustCompile(`"(accessToken|password|token|key|secret)"\s*:\s*"[^"]*"`)
+
+// tracer holds the trace settings and the HAR entries of one run.
+type tracer struct {
+	log       bool
+	harPath   string
+	logf      Logf
+	secrets   []string
+	sensitive map[string]bool
+
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+// newTracer returns nil unless opt asks for a trace or a HAR file.
+func newTracer(opt Options, logf Logf, secrets []string) *tracer {
+	if !opt.Trace && opt.HARFile == "" {
+		return nil
+	}
+	t := &tracer{log: opt.Trace, harPath: opt.HARFile, logf: logf, secrets: secrets, sensitive: map[string]bool{}}
+	for _, h := range sensitiveHeaders {
+		t.sensitive[h] = true
+	}
+	for name := range opt.Headers {
+		t.sensitive[http.CanonicalHeaderKey(name)] = true
+	}
+	return t
+}
+
+func (t *tracer) wrap(base http.RoundTripper) http.RoundTripper {
+	return &traceTransport{t: t, base: base}
+}
+
+func (t *tracer) redact(s string) string {
+	return immich.Redact(sensitiveJSON.ReplaceAllString(s, `"$1":"`+immich.Redacted+`"`), t.secrets...)
+}
+
+func (t *tracer) headers(h http.Header) []harNameValue {
+	out := make([]harNameValue, 0, len(h))
+	for name, values := range h {
+		for _, v := range values {
+			if t.sensitive[http.CanonicalHeaderKey(name)] {
+				v = immich.Redacted
+			}
+			out = append(out, harNameValue{Name: name, Value: t.redact(v)})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// finish records a completed (or failed) exchange.
+func (t *tracer) finish(e harEntry, err error) {
+	if t.log {
+		var b strings.Builder
+		fmt.Fprintf(&b, "trace: %s %s\n", e.Request.Method, e.Request.URL)
+		for _, h := range e.Request.Headers {
+			fmt.Fprintf(&b, "trace:   > %s: %s\n", h.Name, h.Value)
+		}
+		if e.Request.PostData != nil && e.Request.PostData.Text != "" {
+			fmt.Fprintf(&b, "trace:   > %s\n", e.Request.PostData.Text)
+		} else if e.Request.BodySize > 0 {
+			fmt.Fprintf(&b, "trace:   > (%s body)\n", formatBytes(e.Request.BodySize))
+		}
+		if err != nil {
+			fmt.Fprintf(&b, "trace:   error after %s: %s\n", time.Duration(e.Time*float64(time.Millisecond)).Round(time.Millisecond), t.redact(err.Error()))
+		} else {
+			fmt.Fprintf(&b, "trace:   < %d %s in %s (headers after %s)\n", e.Response.Status, e.Response.StatusText,
+				time.Duration(e.Time*float64(time.Millisecond)).Round(time.Millisecond), time.Duration(e.Timings.Wait*float64(time.Millisecond)).Round(time.Millisecond))
+			for _, h := range e.Response.Headers {
+				fmt.Fprintf(&b, "trace:   < %s: %s\n", h.Name, h.Value)
+			}
+			if e.Response.Content.Text != "" {
+				fmt.Fprintf(&b, "trace:   < %s\n", e.Response.Content.Text)
+			}
+		}
+		t.logf("%s", b.String())
+	}
+	if t.harPath != "" {
+		t.mu.Lock()
+		t.entries = append(t.entries, e)
+		t.mu.Unlock()
+	}
+}
+
+// writeHAR writes the recorded exchanges to the HAR file, if one was asked for.
+func (t *tracer) writeHAR() error {
+	if t == nil || t.harPath == "" {
+		return nil
+	}
+	t.mu.Lock()
+	doc := harFile{Log: harLog{Version: "1.2", Creator: harCreator{Name: "immich-uploader", Version: "1"}, Entries: t.entries}}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(t.harPath, b, 0o600); err != nil {
+		return fmt.Errorf("write HAR: %w", err)
+	}
+	return nil
+}
+
+type traceTransport struct {
+	t    *tracer
+	base http.RoundTripper
+}
+
+func (tt *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := tt.t
+	start := time.Now()
+	e := harEntry{
+		StartedDateTime: start,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         t.redact(req.URL.String()),
+			HTTPVersion: "HTTP/1.1",
+			Headers:     t.headers(req.Header),
+			QueryString: []harNameValue{},
+			Cookies:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Cache: struct{}{},
+	}
+
+	var reqBody *captureBody
+	if req.Body != nil && req.Body != http.NoBody {
+		mime := req.Header.Get("Content-Type")
+		limit := traceBodyLimit
+		if strings.HasPrefix(mime, "multipart/") {
+			limit = 0 // file contents
+		}
+		reqBody = &captureBody{rc: req.Body, limit: limit}
+		req = req.Clone(req.Context())
+		req.Body = reqBody
+		e.Request.PostData = &harPostData{MimeType: mime}
+	}
+
+	resp, err := tt.base.RoundTrip(req)
+	wait := time.Since(start)
+	if reqBody != nil {
+		e.Request.BodySize = reqBody.size()
+		e.Request.PostData.Text = t.redact(reqBody.text())
+	}
+	if err != nil {
+		e.Time = ms(wait)
+		e.Timings = harTimings{Send: 0, Wait: ms(wait), Receive: 0}
+		e.Response = harResponse{HTTPVersion: "HTTP/1.1", Headers: []harNameValue{}, Cookies: []harNameValue{},
+			Content: harContent{MimeType: "x-unknown"}, HeadersSize: -1, BodySize: -1, Comment: t.redact(err.Error())}
+		t.finish(e, err)
+		return nil, err
+	}
+
+	e.Request.HTTPVersion = resp.Proto
+	e.Response = harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprint(resp.StatusCode))),
+		HTTPVersion: resp.Proto,
+		Headers:     t.headers(resp.Header),
+		Cookies:     []harNameValue{},
+		Content:     harContent{MimeType: resp.Header.Get("Content-Type")},
+		HeadersSize: -1,
+	}
+	body := &captureBody{rc: resp.Body, limit: traceBodyLimit}
+	body.done = func() {
+		total := time.Since(start)
+		e.Time = ms(total)
+		e.Timings = harTimings{Send: 0, Wait: ms(wait), Receive: ms(total - wait)}
+		e.Response.BodySize = body.size()
+		e.Response.Content.Size = e.Response.BodySize
+		e.Response.Content.Text = t.redact(body.text())
+		t.finish(e, nil)
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// captureBody passes a body through, keeping its first limit bytes. done is
+// called once, when the body is closed.
+//
+// The transport may still be sending a request body when the response
+// arrives, so the captured state is guarded by mu.
+type captureBody struct {
+	rc    io.ReadCloser
+	limit int
+	once  sync.Once
+	done  func()
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+	n   int64
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	b.mu.Lock()
+	if keep := min(n, b.limit-b.buf.Len()); keep > 0 {
+		b.buf.Write(p[:keep])
+	}
+	b.n += int64(n)
+	b.mu.Unlock()
+	return n, err
+}
+
+func (b *captureBody) size() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.n
+}
+
+func (b *captureBody) Close() error {
+	err := b.rc.Close()
+	if b.done != nil {
+		b.once.Do(b.done)
+	}
+	return err
+}
+
+// text is the captured part of the body, noting a truncation.
+func (b *captureBody) text() string {
+	if b.limit == 0 {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.buf.String()
+	if b.n > int64(b.buf.Len()) {
+		s += fmt.Sprintf("... (%s total)", formatBytes(b.n))
+	}
+	return s
+}
+
+// HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/), the fields we fill.
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+	Comment     string         `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
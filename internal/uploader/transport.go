@@ -0,0 +1,250 @@
+package uploader
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Transport: TLS (CA bundle, client certificate), proxy and extra headers
+// apply to every request, API calls and uploads alike.
+//
+// Timeouts. Options.Timeout bounds every API call as a whole. Uploads can
+// legitimately take hours, so they are bounded per phase instead:
+// - ConnectTimeout: dialing and the TLS handshake
+// - ResponseTimeout: from the end of the request body to the response headers
+// - StallTimeout: no body bytes taken by the connection for this long
+// plus a deadline derived from the file size and the throughput seen so far.
+
+const (
+	defaultConnectTimeout  = 30 * time.Second
+	defaultResponseTimeout = 2 * time.Minute
+	defaultStallTimeout    = time.Minute
+
+	// minUploadRate (bytes/s) sizes upload deadlines until the first upload
+	// has finished and the real throughput is known.
+	minUploadRate = 64 << 10
+	// uploadSlack is how many times the expected transfer time an upload may
+	// take before its deadline.
+	uploadSlack = 4
+)
+
+// newHTTPClients returns the client for API calls (bounded by opt.Timeout)
+// and the one for uploads (bounded per request by the client), sharing one
+// transport with the TLS, proxy and header settings of opt. trace and check,
+// if not nil, see every request with the extra headers added; check sits
+// above trace, so requests it refuses are not traced.
+func newHTTPClients(opt Options, trace *tracer, check *validator) (api, upload *http.Client, err error) {
+	connect := cmp.Or(opt.ConnectTimeout, defaultConnectTimeout)
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = (&net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}).DialContext
+	tr.TLSHandshakeTimeout = connect
+	tr.ResponseHeaderTimeout = cmp.Or(opt.ResponseTimeout, defaultResponseTimeout)
+	if tr.TLSClientConfig, err = tlsConfig(opt); err != nil {
+		return nil, nil, err
+	}
+	if opt.Proxy != "" {
+		u, err := url.Parse(opt.Proxy)
+		if err != nil {
+			return nil, nil, fmt.Errorf("proxy: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, nil, fmt.Errorf("proxy %q: expected an http://, https:// or socks5:// URL", opt.Proxy)
+		}
+		tr.Proxy = http.ProxyURL(u)
+	}
+	var rt http.RoundTripper = tr
+	if trace != nil {
+		rt = trace.wrap(rt)
+	}
+	if check != nil {
+		rt = check.wrap(rt)
+	}
+	if len(opt.Headers) > 0 {
+		rt = &headerTransport{base: rt, headers: opt.Headers}
+	}
+	return &http.Client{Transport: rt, Timeout: opt.Timeout}, &http.Client{Transport: rt}, nil
+}
+
+// tlsConfig adds the CA bundle, client certificate and verification setting
+// of opt to the defaults.
+func tlsConfig(opt Options) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: opt.Insecure}
+	if opt.CACert != "" {
+		pem, err := os.ReadFile(opt.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool() // no system pool on this platform
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s: no PEM certificates found", opt.CACert)
+		}
+		cfg.RootCAs = pool
+	}
+	switch {
+	case opt.ClientCert != "" && opt.ClientKey != "":
+		cert, err := tls.LoadX509KeyPair(opt.ClientCert, opt.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	case opt.ClientCert != "" || opt.ClientKey != "":
+		return nil, errors.New("client certificate: both the certificate and the key are needed")
+	}
+	return cfg, nil
+}
+
+// headerTransport adds extra headers (e.g. Cloudflare Access service tokens)
+// to every request.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = values[0]
+			continue
+		}
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	return t.base.RoundTrip(req)
+}
+
+// ParseHeaders parses "Name: value" lines into a header set.
+func ParseHeaders(lines []string) (http.Header, error) {
+	h := http.Header{}
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("header %q: expected \"Name: value\"", line)
+		}
+		h.Add(name, value)
+	}
+	return h, nil
+}
+
+// headerSecrets are the extra header values, which are usually credentials.
+func headerSecrets(h http.Header) []string {
+	var out []string
+	for _, values := range h {
+		out = append(out, values...)
+	}
+	return out
+}
+
+// throughput is the average rate of one upload connection, over the uploads
+// finished so far.
+type throughput struct {
+	mu    sync.Mutex
+	bytes int64
+	dur   time.Duration
+}
+
+func (t *throughput) add(n int64, d time.Duration) {
+	if n <= 0 || d <= 0 {
+		return
+	}
+	t.mu.Lock()
+	t.bytes += n
+	t.dur += d
+	t.mu.Unlock()
+}
+
+// rate returns bytes/s, or 0 before the first upload.
+func (t *throughput) rate() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dur <= 0 {
+		return 0
+	}
+	return int64(float64(t.bytes) / t.dur.Seconds())
+}
+
+// uploadDeadline bounds an upload of size bytes at uploadSlack times its
+// expected duration, at the observed throughput, but never below the API
+// timeout. Under a rate limit the expected rate is at most this upload's
+// share of the lowest limit the schedule may switch to mid-upload: the limit
+// is shared by every worker that may be sending at once.
+func (c *client) uploadDeadline(size int64) time.Duration {
+	rate := c.tput.rate()
+	if rate <= 0 {
+		rate = minUploadRate
+	}
+	if c.limiter != nil {
+		if limit := c.limiter.lowest() / int64(max(c.workers, 1)); limit > 0 && limit < rate {
+			rate = limit
+		}
+	}
+	expected := time.Duration(float64(size) / float64(rate) * float64(time.Second))
+	return max(uploadSlack*expected+c.responseTimeout, c.HTTP.Timeout)
+}
+
+// progressReader is an upload body that records when the connection last
+// took bytes from it.
+type progressReader struct {
+	r    io.Reader
+	n    atomic.Int64
+	last atomic.Int64 // UnixNano
+	done atomic.Bool  // body fully sent
+}
+
+func newProgressReader(r io.Reader) *progressReader {
+	p := &progressReader{r: r}
+	p.last.Store(time.Now().UnixNano())
+	return p
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.n.Add(int64(n))
+		p.last.Store(time.Now().UnixNano())
+	}
+	if err == io.EOF {
+		p.done.Store(true)
+	}
+	return n, err
+}
+
+// watch cancels the upload once no bytes have moved for timeout. It returns
+// when ctx is done or the body has been sent; waiting for the response is
+// then up to ResponseTimeout.
+func (p *progressReader) watch(ctx context.Context, cancel context.CancelCauseFunc, timeout time.Duration) {
+	t := time.NewTicker(max(timeout/4, 100*time.Millisecond))
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			if p.done.Load() {
+				return
+			}
+			if idle := now.Sub(time.Unix(0, p.last.Load())); idle >= timeout {
+				cancel(fmt.Errorf("upload stalled: no progress for %s", idle.Round(time.Second)))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package uploader
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUploadDeadlineRateLimit(t *testing.T) {
+	const size = 10 << 20
+	newTestClient := func(limit int64, schedule string, workers int) *client {
+		t.Helper()
+		windows, err := parseRateSchedule(schedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := newClient("http://immich.invalid/api", nil, &http.Client{Timeout: time.Minute}, nil)
+		c.limiter = newRateLimiter(limit, windows)
+		c.workers = workers
+		return c
+	}
+	for _, tc := range []struct {
+		name     string
+		limit    int64
+		schedule string
+		workers  int
+		rate     int64 // slowest rate the upload may get, bytes/s
+	}{
+		// more workers than uploadSlack: each gets an eighth of the limit
+		{"shared limit", 100 << 10, "", 8, 100 << 10 / 8},
+		// the schedule may drop to its lowest window mid-upload
+		{"schedule", 0, "00:00-12:00=1MiB,12:00-00:00=64K", 4, 64 << 10 / 4},
+		{"limit below schedule", 32 << 10, "00:00-00:00=0", 2, 32 << 10 / 2},
+	} {
+		c := newTestClient(tc.limit, tc.schedule, tc.workers)
+		// Even after fast uploads elsewhere, the limit bounds the rate.
+		c.tput.add(100<<20, time.Second)
+		transfer := time.Duration(float64(size) / float64(tc.rate) * float64(time.Second))
+		if got := c.uploadDeadline(size); got < transfer {
+			t.Errorf("%s: deadline %s, shorter than the %s the upload takes at its share of the limit", tc.name, got, transfer)
+		}
+	}
+
+	// Without a limit the observed throughput decides.
+	c := newTestClient(0, "", 8)
+	c.tput.add(10<<20, time.Second)
+	if got := c.uploadDeadline(size); got != time.Minute {
+		t.Errorf("unlimited: deadline %s, want the API timeout", got)
+	}
+}
@@ -1,101 +1,75 @@
 package uploader
 
 import (
-	"bytes"
+	"cmp"
 	"context"
 	"crypto/sha1"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
-	"sort"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"golang.org/x/term"
-	"time"
+
+	"immich-uploader/immich"
 )
 
-// Immich API (v2 stable):
-// - POST   /albums                 (CreateAlbumDto)
+// Immich API calls go through the immich package, which picks the endpoints
+// of the server's release:
 // - GET    /albums                 (AlbumResponseDto[])
+// - POST   /albums                 (CreateAlbumDto)
 // - PUT    /albums/{id}/assets     (BulkIdsDto)
 // - POST   /assets                 (multipart AssetMediaCreateDto)
-// Auth: x-api-key: <api key>
-
-type albumResponse struct {
-	ID        string `json:"id"`
-	AlbumName string `json:"albumName"`
-}
+// - POST   /assets/bulk-upload-check (AssetBulkUploadCheckDto)
+// Auth: x-api-key: <api key>, a bearer token from a password login (see
+// auth.go) or a shared link (see sharedlink.go)
 
-type createAlbumRequest struct {
-	AlbumName string `json:"albumName"`
-}
+// client is the Immich client of a run, plus what the uploader adds around
+// uploads: rate limiting, per-upload timeouts and throughput tracking.
+type client struct {
+	// HTTP makes API calls, bounded by Options.Timeout; UploadHTTP makes
+	// uploads, bounded per request (see transport.go).
+	*immich.Client
 
-type assetUploadResponse struct {
-	ID     string `json:"id"`
-	Status string `json:"status"`
-}
+	limiter *rateLimiter // shared by all upload workers; nil = unlimited
 
-type bulkIDs struct {
-	IDs []string `json:"ids"`
+	responseTimeout time.Duration
+	stallTimeout    time.Duration
+	tput            *throughput
+	workers         int // uploads that may run at once, sharing limiter
 }
 
-type client struct {
-	baseURL string
-	apiKey  string
-	hc      *http.Client
+// newClient returns a client for the API at baseURL that authenticates with
+// auth until the run switches to a password session or a shared link.
+func newClient(baseURL string, auth immich.Auth, hc, uploadHC *http.Client) *client {
+	ic := immich.New(baseURL, auth)
+	ic.HTTP, ic.UploadHTTP = hc, uploadHC
+	ic.API = immich.Latest
+	ic.Retry = immich.Retry{Attempts: apiRetries}
+	return &client{Client: ic, tput: &throughput{}}
 }
 
-func (c *client) doJSON(ctx context.Context, method, urlPath string, reqBody any, out any) error {
-	var body io.Reader
-	if reqBody != nil {
-		b, err := json.Marshal(reqBody)
-		if err != nil {
-			return err
-		}
-		body = bytes.NewReader(b)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+urlPath, body)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("x-api-key", c.apiKey)
-	if reqBody != nil {
-		req.Header.Set("Content-Type", "application/json")
-	}
-
-	resp, err := c.hc.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	b, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("%s %s failed: status=%d body=%s", method, urlPath, resp.StatusCode, strings.TrimSpace(string(b)))
-	}
+// apiRetries is how often an API call is retried when the server asks to
+// slow down or, for idempotent calls, is briefly unavailable. Longer outages
+// are the circuit breaker's job.
+const apiRetries = 2
 
-	if out != nil {
-		if err := json.Unmarshal(b, out); err != nil {
-			return fmt.Errorf("decode response: %w (body=%s)", err, strings.TrimSpace(string(b)))
-		}
-	}
-	return nil
+// secrets are the credentials that must never appear in output.
+func (c *client) secrets() []string {
+	return c.Auth.Secrets()
 }
 
 func (c *client) getAllAlbums(ctx context.Context) (map[string]string, error) {
-	var albums []albumResponse
-	if err := c.doJSON(ctx, http.MethodGet, "/albums", nil, &albums); err != nil {
+	albums, err := c.ListAlbums(ctx, nil)
+	if err != nil {
 		return nil, err
 	}
 	m := make(map[string]string, len(albums))
@@ -106,104 +80,79 @@ func (c *client) getAllAlbums(ctx context.Context) (map[string]string, error) {
 }
 
 func (c *client) createAlbum(ctx context.Context, name string) (string, error) {
-	var out albumResponse
-	if err := c.doJSON(ctx, http.MethodPost, "/albums", createAlbumRequest{AlbumName: name}, &out); err != nil {
+	album, err := c.CreateAlbum(ctx, immich.CreateAlbum{AlbumName: name})
+	if err != nil {
 		return "", err
 	}
-	return out.ID, nil
+	return album.ID, nil
 }
 
 func (c *client) addAssetsToAlbum(ctx context.Context, albumID string, assetIDs []string) error {
 	if len(assetIDs) == 0 {
 		return nil
 	}
-	path := fmt.Sprintf("/albums/%s/assets", albumID)
-	return c.doJSON(ctx, http.MethodPut, path, bulkIDs{IDs: assetIDs}, nil)
+	_, err := c.AddAssetsToAlbum(ctx, albumID, assetIDs)
+	return err
 }
 
-func (c *client) uploadAsset(ctx context.Context, filePath, deviceID, deviceAssetID string, createdAt, modifiedAt time.Time, checksumSHA1 string) (assetUploadResponse, error) {
-	// Stream multipart upload using io.Pipe to avoid buffering entire files in RAM.
-	pr, pw := io.Pipe()
-	mw := multipart.NewWriter(pw)
-	contentType := mw.FormDataContentType()
-
-	go func() {
-		defer func() {
-			_ = pw.Close()
-		}()
-
-		// required fields
-		if err := mw.WriteField("deviceId", deviceID); err != nil {
-			_ = pw.CloseWithError(err)
-			return
-		}
-		if err := mw.WriteField("deviceAssetId", deviceAssetID); err != nil {
-			_ = pw.CloseWithError(err)
-			return
-		}
-		if err := mw.WriteField("fileCreatedAt", createdAt.UTC().Format(time.RFC3339Nano)); err != nil {
-			_ = pw.CloseWithError(err)
-			return
-		}
-		if err := mw.WriteField("fileModifiedAt", modifiedAt.UTC().Format(time.RFC3339Nano)); err != nil {
-			_ = pw.CloseWithError(err)
-			return
-		}
-		if err := mw.WriteField("filename", filepath.Base(filePath)); err != nil {
-			_ = pw.CloseWithError(err)
-			return
-		}
-
-		part, err := mw.CreateFormFile("assetData", filepath.Base(filePath))
-		if err != nil {
-			_ = pw.CloseWithError(err)
-			return
-		}
-		f, err := os.Open(filePath)
-		if err != nil {
-			_ = pw.CloseWithError(err)
-			return
-		}
-		_, err = io.Copy(part, f)
-		_ = f.Close()
-		if err != nil {
-			_ = pw.CloseWithError(err)
-			return
-		}
-
-		if err := mw.Close(); err != nil {
-			_ = pw.CloseWithError(err)
-			return
-		}
-	}()
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/assets", pr)
-	if err != nil {
-		return assetUploadResponse{}, err
-	}
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("x-api-key", c.apiKey)
-	req.Header.Set("Content-Type", contentType)
-	if checksumSHA1 != "" {
-		req.Header.Set("x-immich-checksum", checksumSHA1)
-	}
-
-	resp, err := c.hc.Do(req)
+// uploadAsset uploads one file within a deadline derived from its size,
+// cancelling the upload if it stalls. Timeouts are reported as network
+// errors, so the circuit breaker treats them like a dropped connection.
+func (c *client) uploadAsset(ctx context.Context, filePath, deviceID, deviceAssetID string, createdAt, modifiedAt time.Time, checksumSHA1 string) (immich.UploadResult, error) {
+	st, err := os.Stat(filePath)
 	if err != nil {
-		return assetUploadResponse{}, err
-	}
-	defer resp.Body.Close()
-	b, _ := io.ReadAll(resp.Body)
+		return immich.UploadResult{}, err
+	}
+	runCtx := ctx
+	deadline := c.uploadDeadline(st.Size())
+	ctx, cancel := context.WithTimeoutCause(ctx, deadline, fmt.Errorf("upload not finished within %s", deadline.Round(time.Second)))
+	defer cancel()
+	ctx, stall := context.WithCancelCause(ctx)
+	defer stall(nil)
+
+	var body *uploadBody // of the last attempt
+	start := time.Now()
+	res, err := c.UploadAsset(ctx, immich.AssetUpload{
+		DeviceAssetID:  deviceAssetID,
+		DeviceID:       deviceID,
+		FileCreatedAt:  createdAt,
+		FileModifiedAt: modifiedAt,
+		Filename:       filepath.Base(filePath),
+		Checksum:       checksumSHA1,
+		Open: func() (io.ReadCloser, error) {
+			f, err := os.Open(filePath)
+			if err != nil {
+				return nil, err
+			}
+			var r io.Reader = f
+			if c.limiter != nil {
+				r = &rateLimitedReader{ctx: ctx, r: f, l: c.limiter}
+			}
+			start = time.Now()
+			body = &uploadBody{progressReader: newProgressReader(r), f: f}
+			go body.watch(ctx, stall, c.stallTimeout)
+			return body, nil
+		},
+	})
+	if err != nil && ctx.Err() != nil && runCtx.Err() == nil {
+		return res, &immich.NetworkError{Op: "upload", Err: context.Cause(ctx)}
+	}
+	if err == nil && body != nil {
+		c.tput.add(body.n.Load(), time.Since(start))
+	}
+	return res, err
+}
 
-	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		return assetUploadResponse{}, fmt.Errorf("upload failed: status=%d body=%s", resp.StatusCode, strings.TrimSpace(string(b)))
-	}
+// uploadBody is the file of one upload attempt. Closing it (sent or not)
+// ends its stall watch.
+type uploadBody struct {
+	*progressReader
+	f *os.File
+}
 
-	var out assetUploadResponse
-	if err := json.Unmarshal(b, &out); err != nil {
-		return assetUploadResponse{}, fmt.Errorf("decode upload response: %w (body=%s)", err, strings.TrimSpace(string(b)))
-	}
-	return out, nil
+func (b *uploadBody) Close() error {
+	b.done.Store(true)
+	return b.f.Close()
 }
 
 func sha1HexString(s string) string {
@@ -224,7 +173,9 @@ func sha1File(path string) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func isMediaFile(name string) bool {
+// IsMediaFile reports whether name has one of the photo or video extensions
+// uploaded unless Hooks.Filter is set.
+func IsMediaFile(name string) bool {
 	ext := strings.ToLower(filepath.Ext(name))
 	switch ext {
 	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".heic", ".heif", ".tif", ".tiff", ".bmp",
@@ -372,28 +323,171 @@ func colorize(enabled bool, code, text string) string {
 }
 
 type Options struct {
-	BaseURL       string
-	APIKey        string
+	BaseURL string
+	// APIKey authenticates with an API key. If it is empty, Email and
+	// Password are used to log in for the duration of the run instead.
+	APIKey   string
+	Email    string
+	Password string
+	// SharedLink switches to shared link mode: a key or an Immich share URL
+	// (with "allow upload") whose album receives every album folder. Albums
+	// are not created and the duplicate preflight is skipped in this mode.
+	SharedLink         string
+	SharedLinkPassword string
+	// CACert is a PEM bundle trusted in addition to the system roots (private
+	// CA); ClientCert and ClientKey are a PEM certificate and key for mTLS.
+	// Insecure disables certificate verification altogether.
+	CACert     string
+	ClientCert string
+	ClientKey  string
+	Insecure   bool
+	// Proxy is an http://, https:// or socks5:// proxy URL (empty = the
+	// HTTPS_PROXY / HTTP_PROXY / NO_PROXY environment variables).
+	Proxy string
+	// Headers are sent with every request, e.g. the CF-Access-Client-Id and
+	// CF-Access-Client-Secret of a Cloudflare Access service token. Their
+	// values are redacted from output like the API key.
+	Headers http.Header
+	// Trace logs every request and response (credentials redacted, bodies
+	// truncated); HARFile, if set, receives them as a HAR file at the end of
+	// the run.
+	Trace         bool
+	HARFile       string
 	Root          string
 	Deep          bool
 	Checksum      bool
 	BatchSize     int
 	Workers       int
 	SmallestFirst bool
-	IgnoreDir     string
-	Timeout       time.Duration
-	// DedupeAdd: if true, rely on server-side checksum dedupe during upload.
-	// (Future: add /assets/bulk-upload-check preflight.)
+	// ScanReaders is how many directories are read in parallel while scanning
+	// an album folder (<= 1 = sequential walk).
+	ScanReaders int
+	IgnoreDir   string
+	// Timeout bounds each API call. Uploads are bounded instead by
+	// ConnectTimeout (dial and TLS handshake), ResponseTimeout (end of the
+	// body to the response headers), StallTimeout (no bytes sent for that
+	// long) and a deadline derived from the file size and the observed
+	// throughput, but never shorter than Timeout. 0 = default (30s, 2m, 1m).
+	Timeout         time.Duration
+	ConnectTimeout  time.Duration
+	ResponseTimeout time.Duration
+	StallTimeout    time.Duration
+	// SettleTime is how long a file must stop changing (size and mtime)
+	// before it is uploaded; files modified more recently are skipped for
+	// this run (0 = no quiet period, except in watch mode where it defaults
+	// to 10s). Independently, files whose size/mtime change between scan and
+	// upload are skipped, and files that change during the upload are not
+	// moved. WatchRescan is the interval of the full rescan watch mode runs
+	// as a safety net for missed notifications.
+	SettleTime  time.Duration
+	WatchRescan time.Duration
+	// RateLimit caps upload bandwidth in bytes/s across all workers (0 = unlimited).
+	// RateSchedule overrides it by time of day: "HH:MM-HH:MM=RATE[,...]",
+	// e.g. "08:00-23:00=1MiB" (a RATE of 0 means unlimited).
+	RateLimit    int64
+	RateSchedule string
+	// DedupeAdd: if true, files the server already has (found by the
+	// /assets/bulk-upload-check preflight or by checksum dedupe during upload)
+	// are still added to the album.
 	DedupeAdd bool
-	TUI       bool
-	TUIAuto   bool
-	TUIStyle  string
-	NoANSI    bool
+	// HashWorkers and PreflightWorkers size the hashing and duplicate-check
+	// stages; Workers sizes the upload stage.
+	HashWorkers      int
+	PreflightWorkers int
+	NoPreflight      bool
+	// ChecksumCache is the path of the persistent checksum cache
+	// (empty = default location under the user cache dir).
+	ChecksumCache        string
+	NoChecksumCache      bool
+	RebuildChecksumCache bool
+	// LockWait is how long to wait for another run holding the lock on Root
+	// (0 = fail immediately with ErrLocked). NoLock skips the lock entirely.
+	LockWait time.Duration
+	NoLock   bool
+	// SpaceCheck counts the files left for upload after the duplicate
+	// preflight, as they reach the upload stage, against the server's free
+	// disk space and the user's quota, read at the start of the run (and of
+	// every watch batch): SpaceCheckAbort (default) uploads what fits, starts
+	// no upload after the first that would exceed them and ends the run with
+	// ErrServerFull, SpaceCheckWarn only logs, SpaceCheckOff skips the check.
+	// Either way, uploads stop as soon as the server reports that it is out of
+	// space.
+	SpaceCheck string
+	// ValidateOpenAPI checks every request and response against the bundled
+	// Immich OpenAPI spec: ValidateWarn logs each distinct mismatch,
+	// ValidateStrict also fails the request (and stops the run). For
+	// debugging and tests; ValidateOff (default) skips it.
+	ValidateOpenAPI string
+	// Hooks customize the run for programs embedding it.
+	Hooks Hooks
+
+	TUI      bool
+	TUIAuto  bool
+	TUIStyle string
+	NoANSI   bool
 }
 
 type Logf func(format string, args ...any)
 
+// Summary describes the outcome of one run.
+type Summary struct {
+	Start      time.Time
+	End        time.Time
+	Albums     int
+	Files      int // uploaded or already on the server
+	Duplicates int
+	Failed     int
+	Skipped    int
+	MoveFailed int
+	Bytes      int64
+
+	// CacheHits and CacheMisses count the checksum cache lookups; both are 0
+	// without a cache.
+	CacheHits   int64
+	CacheMisses int64
+}
+
+func (s Summary) String() string {
+	out := fmt.Sprintf("albums %d | files %d | dup %d | fail %d | skip %d | moved-fail %d | %s in %s",
+		s.Albums, s.Files, s.Duplicates, s.Failed, s.Skipped, s.MoveFailed, formatBytes(s.Bytes), s.End.Sub(s.Start).Round(time.Second))
+	if s.CacheHits+s.CacheMisses > 0 {
+		out += fmt.Sprintf(" | cache %d hits, %d misses", s.CacheHits, s.CacheMisses)
+	}
+	return out
+}
+
+// Run uploads every album folder under opt.Root once.
 func Run(ctx context.Context, opt Options, logf Logf) error {
+	_, err := RunSummary(ctx, opt, logf)
+	return err
+}
+
+// RunSummary is Run, also returning what the run did.
+func RunSummary(ctx context.Context, opt Options, logf Logf) (Summary, error) {
+	return run(ctx, opt, logf, newScanner(opt))
+}
+
+// eventSource feeds album/file events into a run. release is called once a
+// file has left the pipeline (finished, failed or dropped).
+type eventSource interface {
+	events(ctx context.Context, logf Logf) (<-chan scanEvent, error)
+	release(path string)
+}
+
+func run(ctx context.Context, opt Options, logf Logf, src eventSource) (_ Summary, err error) {
+	var link *immich.SharedLink
+	if opt.SharedLink != "" {
+		if link, err = immich.ParseSharedLink(opt.SharedLink, opt.SharedLinkPassword); err != nil {
+			return Summary{}, err
+		}
+		opt.APIKey, opt.Email, opt.Password = "", "", ""
+	}
+	secrets := append([]string{opt.APIKey, opt.Password}, headerSecrets(opt.Headers)...)
+	if link != nil {
+		secrets = append(secrets, link.Key, link.Password)
+	}
+	defer func() { err = immich.RedactError(err, secrets...) }()
+
 	tuiEnabled := opt.TUI
 	noANSI := opt.NoANSI
 	style := tuiStyle(opt.TUIStyle)
@@ -410,40 +504,132 @@ func Run(ctx context.Context, opt Options, logf Logf) error {
 	if logf == nil {
 		logf = func(format string, args ...any) { fmt.Fprintf(os.Stdout, format, args...) }
 	}
-	if opt.APIKey == "" {
-		return fmt.Errorf("missing API key")
+	if link == nil && opt.APIKey == "" && (opt.Email == "" || opt.Password == "") {
+		return Summary{}, fmt.Errorf("missing API key (or email and password, or a shared link)")
 	}
+	logf = redactLogf(logf, secrets...)
 	if opt.Root == "" {
-		return fmt.Errorf("missing root")
+		return Summary{}, fmt.Errorf("missing root")
 	}
 
-	b := strings.TrimRight(opt.BaseURL, "/")
-	c := &client{baseURL: b, apiKey: opt.APIKey, hc: &http.Client{Timeout: opt.Timeout}}
-
-	albums, err := c.getAllAlbums(ctx)
+	schedule, err := parseRateSchedule(opt.RateSchedule)
 	if err != nil {
-		return fmt.Errorf("failed to list albums: %w", err)
+		return Summary{}, fmt.Errorf("rate schedule: %w", err)
+	}
+	switch opt.SpaceCheck {
+	case "":
+		opt.SpaceCheck = SpaceCheckAbort
+	case SpaceCheckAbort, SpaceCheckWarn, SpaceCheckOff:
+	default:
+		return Summary{}, fmt.Errorf("space check %q: expected %s, %s or %s", opt.SpaceCheck, SpaceCheckAbort, SpaceCheckWarn, SpaceCheckOff)
 	}
 
-	entries, err := os.ReadDir(opt.Root)
+	if !opt.NoLock {
+		lock, err := acquireRootLock(ctx, opt.Root, opt.LockWait, logf)
+		if err != nil {
+			return Summary{}, err
+		}
+		defer lock.release()
+	}
+
+	b := strings.TrimRight(opt.BaseURL, "/")
+	trace := newTracer(opt, logf, secrets)
+	defer func() {
+		if err := trace.writeHAR(); err != nil {
+			logf("%v\n", err)
+		}
+	}()
+	check, err := newValidator(opt, logf)
 	if err != nil {
-		return fmt.Errorf("read root dir: %w", err)
+		return Summary{}, err
+	}
+	defer check.summary()
+	hc, uploadHC, err := newHTTPClients(opt, trace, check)
+	if err != nil {
+		return Summary{}, err
+	}
+	if opt.Insecure {
+		logf("warning: TLS certificate verification is disabled (--insecure)\n")
+	}
+	c := newClient(b, immich.APIKey(opt.APIKey), hc, uploadHC)
+	c.limiter = newRateLimiter(opt.RateLimit, schedule)
+	c.workers = opt.Workers
+	c.responseTimeout = cmp.Or(opt.ResponseTimeout, defaultResponseTimeout)
+	c.stallTimeout = cmp.Or(opt.StallTimeout, defaultStallTimeout)
+	switch {
+	case link != nil:
+		c.Auth = link
+	case opt.APIKey == "":
+		c.Auth = &immich.Session{Email: opt.Email, Password: opt.Password}
+	}
+	if err := c.checkServer(ctx, logf); err != nil {
+		return Summary{}, err
 	}
 
+	var albums map[string]string
+	sharedAlbumID := ""
+	switch c.Auth.(type) {
+	case *immich.SharedLink:
+		album, err := c.openSharedLink(ctx)
+		if err != nil {
+			return Summary{}, err
+		}
+		sharedAlbumID = album.ID
+		opt.NoPreflight = true // bulk-upload-check is not available to shared links
+		logf("Shared link: uploading every album folder into %q\n", album.AlbumName)
+	case *immich.Session:
+		user, err := c.login(ctx)
+		if err != nil {
+			return Summary{}, err
+		}
+		logf("Logged in as %s (%s)\n", user.Name, user.UserEmail)
+		defer func() {
+			if err := c.logout(); err != nil {
+				logf("logout: %v\n", err)
+			}
+		}()
+	}
+
+	if link == nil {
+		albums, err = c.getAllAlbums(ctx)
+		if err != nil {
+			return Summary{}, fmt.Errorf("failed to list albums: %w", err)
+		}
+	}
+
+	// Stop background goroutines (scanner, pipeline feeder, TUI refresh) when Run returns.
+	// The circuit breaker cancels with the reason the run was aborted.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
 	deviceID := "immich-folder-uploader-" + runtime.GOOS
 
+	var cache *checksumCache
+	if opt.Checksum && !opt.NoChecksumCache {
+		cache = openChecksumCache(opt.ChecksumCache, opt.RebuildChecksumCache)
+		defer func() {
+			cache.prune(opt.Root)
+			if err := cache.save(); err != nil {
+				logf("save checksum cache: %v\n", err)
+			}
+			hits, misses, rate := cache.stats()
+			logf("Checksum cache: %d hits, %d misses (%.1f%% hit rate)\n", hits, misses, rate)
+		}()
+		go cache.autosave(ctx, checksumCacheSaveInterval, logf)
+	}
+
 	type tuiState struct {
 		sync.Mutex
 		albumName       string
-		albumTotal      int
-		albumDone       int
-		albumBytes      int64
-		albumTotalBytes int64
-		albumStart      time.Time
+		queued          int
+		queuedBytes     int64
+		done            int
+		doneBytes       int64
 		globalAlbums    int
 		globalFiles     int
 		globalDup       int
 		globalFailed    int
+		globalSkipped   int
 		globalMovedFail int
 		globalBytes     int64
 		globalStart     time.Time
@@ -455,18 +641,17 @@ func Run(ctx context.Context, opt Options, logf Logf) error {
 		defer tui.Unlock()
 		pretty := !noANSI && style == tuiStylePretty
 
-		if tui.albumName == "" {
-			elapsed := time.Since(tui.globalStart)
-			base := fmt.Sprintf("Idle | elapsed %s | albums %d | files %d | dup %d | fail %d | moved-fail %d | %s", formatDuration(elapsed), tui.globalAlbums, tui.globalFiles, tui.globalDup, tui.globalFailed, tui.globalMovedFail, formatBytes(tui.globalBytes))
+		elapsed := time.Since(tui.globalStart)
+		if tui.done >= tui.queued {
+			base := fmt.Sprintf("Idle | elapsed %s | albums %d | files %d | dup %d | fail %d | skip %d | moved-fail %d | %s", formatDuration(elapsed), tui.globalAlbums, tui.globalFiles, tui.globalDup, tui.globalFailed, tui.globalSkipped, tui.globalMovedFail, formatBytes(tui.globalBytes))
 			return colorize(pretty, "90", base)
 		}
 
-		elapsed := time.Since(tui.albumStart)
-		avg := formatRate(tui.albumBytes, elapsed)
+		avg := formatRate(tui.doneBytes, elapsed)
 		eta := "-"
-		if tui.albumBytes > 0 && elapsed > 0 {
-			rate := float64(tui.albumBytes) / elapsed.Seconds()
-			rem := float64(tui.albumTotalBytes - tui.albumBytes)
+		if tui.doneBytes > 0 && elapsed > 0 {
+			rate := float64(tui.doneBytes) / elapsed.Seconds()
+			rem := float64(tui.queuedBytes - tui.doneBytes)
 			if rate > 0 && rem > 0 {
 				eta = formatDuration(time.Duration(rem/rate) * time.Second)
 			} else {
@@ -475,8 +660,8 @@ func Run(ctx context.Context, opt Options, logf Logf) error {
 		}
 
 		name := colorize(pretty, "36", tui.albumName)
-		count := colorize(pretty, "33", fmt.Sprintf("%d/%d", tui.albumDone, tui.albumTotal))
-		bytes := colorize(pretty, "32", fmt.Sprintf("%s/%s", formatBytes(tui.albumBytes), formatBytes(tui.albumTotalBytes)))
+		count := colorize(pretty, "33", fmt.Sprintf("%d/%d", tui.done, tui.queued))
+		bytes := colorize(pretty, "32", fmt.Sprintf("%s/%s", formatBytes(tui.doneBytes), formatBytes(tui.queuedBytes)))
 		speed := colorize(pretty, "35", "avg "+avg)
 		etaS := colorize(pretty, "35", "ETA "+eta)
 		dup := colorize(pretty, "34", fmt.Sprintf("dup %d", tui.globalDup))
@@ -533,250 +718,258 @@ func Run(ctx context.Context, opt Options, logf Logf) error {
 		}()
 	}
 
-	for _, e := range entries {
-		if !e.IsDir() {
-			continue
-		}
-		folderName := e.Name()
-		if folderName == opt.IgnoreDir {
-			continue
-		}
-		folderPath := filepath.Join(opt.Root, folderName)
+	events, err := src.events(ctx, eventf)
+	if err != nil {
+		return Summary{}, err
+	}
 
-		albumID, ok := albums[folderName]
-		if !ok {
-			eventf("Creating album: %s\n", folderName)
-			id, err := c.createAlbum(ctx, folderName)
-			if err != nil {
-				eventf("create album %q failed: %v\n", folderName, err)
-				continue
-			}
-			albumID = id
-			albums[folderName] = id
-		} else {
-			eventf("Using existing album: %s\n", folderName)
-		}
+	tracker := &albumTracker{}
+	brk := newBreaker(c, cancel, eventf)
 
-		if _, err := ensureIgnoreAlbumDir(opt.Root, opt.IgnoreDir, folderName); err != nil {
-			eventf("failed to create ignore folder for %s: %v\n", folderName, err)
-			continue
+	albumDone := func(out AlbumOutcome) {
+		if h := opt.Hooks.AlbumDone; h != nil {
+			h(out)
 		}
+	}
 
-		var files []string
-		walkFn := func(path string, d os.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			if d.IsDir() {
-				if path != folderPath && !opt.Deep {
-					return filepath.SkipDir
-				}
-				return nil
-			}
-			name := d.Name()
-			if strings.HasPrefix(name, ".") {
-				return nil
-			}
-			if !isMediaFile(name) {
-				return nil
-			}
-			files = append(files, path)
-			return nil
+	// finalize adds the album's assets once its last file has completed.
+	finalize := func(a *albumState) {
+		if a.walkErr != nil {
+			eventf("walk %s: %v\n", a.name, a.walkErr)
 		}
+		out := AlbumOutcome{Folder: a.name, Album: a.album, AlbumID: a.id}
+		var errs []error
+		defer func() {
+			out.Err = errors.Join(errs...)
+			albumDone(out)
+			// drop the ignore folder created up front if nothing was moved into it
+			_ = os.Remove(filepath.Join(opt.Root, opt.IgnoreDir, a.name))
+			tui.Lock()
+			tui.globalAlbums++
+			tui.Unlock()
+		}()
 
-		if err := filepath.WalkDir(folderPath, walkFn); err != nil {
-			eventf("walk %s: %v\n", folderName, err)
-			continue
-		}
-		if len(files) == 0 {
-			eventf("No media files in %s, skipping\n", folderName)
-			continue
+		if a.queued == 0 {
+			eventf("No media files in %s, skipping\n", a.name)
+			return
 		}
-
-		if opt.SmallestFirst {
-			sort.Slice(files, func(i, j int) bool {
-				sti, err1 := os.Stat(files[i])
-				stj, err2 := os.Stat(files[j])
-				if err1 != nil && err2 != nil {
-					return files[i] < files[j]
-				}
-				if err1 != nil {
-					return false
-				}
-				if err2 != nil {
-					return true
-				}
-				if sti.Size() == stj.Size() {
-					return files[i] < files[j]
-				}
-				return sti.Size() < stj.Size()
-			})
+		if a.skipped > 0 {
+			eventf("Album %s: %d files skipped for now (retry later)\n", a.name, a.skipped)
 		}
-
-		totalBytes := int64(0)
-		for _, fp := range files {
-			if st, err := os.Stat(fp); err == nil {
-				totalBytes += st.Size()
-			}
+		if len(a.assetIDs) == 0 {
+			eventf("No uploads succeeded for %s\n", a.name)
+			return
 		}
-		albumStart := time.Now()
-		uploadedBytes := int64(0)
-		eventf("Uploading %d files (%s) from %s...\n", len(files), formatBytes(totalBytes), folderName)
-
-		uploadedIDs := make([]string, 0, len(files))
-		uploadErrors := 0
-
-		type uploadJob struct {
-			idx  int
-			path string
-			size int64
+		if ctx.Err() != nil {
+			eventf("Album %s: run stopped before %d uploaded assets were added to the album\n", a.name, len(a.assetIDs))
+			errs = append(errs, fmt.Errorf("%d uploaded assets not added to the album: %w", len(a.assetIDs), context.Cause(ctx)))
+			return
 		}
-		type uploadResult struct {
-			idx   int
-			path  string
-			size  int64
-			asset assetUploadResponse
-			dur   time.Duration
-			err   error
+		if a.errors > 0 {
+			eventf("Album %s: %d upload errors (still adding successful assets to album)\n", a.name, a.errors)
 		}
 
-		jobs := make(chan uploadJob)
-		results := make(chan uploadResult)
-		wg := sync.WaitGroup{}
-
-		workerCount := opt.Workers
-		if workerCount < 1 {
-			workerCount = 1
-		}
-		if workerCount > len(files) {
-			workerCount = len(files)
+		for _, ch := range chunk(a.assetIDs, opt.BatchSize) {
+			err := c.addAssetsToAlbum(ctx, a.id, ch)
+			brk.observeOne(ctx, err)
+			if err != nil {
+				eventf("add assets to album %s failed: %v\n", a.name, err)
+				errs = append(errs, fmt.Errorf("add assets to album: %w", err))
+				continue
+			}
+			out.Added += len(ch)
 		}
+		eventf("Album %s: added %d assets (%s in %s)\n", a.name, len(a.assetIDs), formatBytes(a.doneBytes), time.Since(a.start).Round(time.Second))
+	}
 
-		for w := 0; w < workerCount; w++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				for job := range jobs {
-					st, err := os.Stat(job.path)
+	space := c.newSpaceBudget(ctx, opt.SpaceCheck, eventf)
+	pl := &pipeline{c: c, opt: opt, cache: cache, deviceID: deviceID, breaker: brk, space: space}
+	in := make(chan *uploadJob)
+	results := pl.run(ctx, in)
+
+	// Feed scanned files into the run-wide pipeline, resolving each album as
+	// the scanner reaches it. Workers stay busy across album boundaries.
+	go func() {
+		defer close(in)
+		var cur *albumState
+		idx := 0
+		for ev := range events {
+			switch ev.kind {
+			case scanBatch:
+				pl.refreshSpace(ctx)
+
+			case scanAlbumStart:
+				cur = nil
+				folderName := ev.album
+				albumName := folderName
+				if resolve := opt.Hooks.Album; resolve != nil {
+					name, err := resolve(ctx, folderName)
 					if err != nil {
-						results <- uploadResult{idx: job.idx, path: job.path, size: job.size, err: err}
+						eventf("album for %s: %v\n", folderName, err)
+						albumDone(AlbumOutcome{Folder: folderName, Err: err})
 						continue
 					}
-
-					rel, _ := filepath.Rel(opt.Root, job.path)
-					deviceAssetID := sha1HexString(rel)
-					created := st.ModTime()
-					modified := st.ModTime()
-
-					sum := ""
-					if opt.Checksum {
-						s, err := sha1File(job.path)
-						if err == nil {
-							sum = s
-						}
+					if name == "" {
+						eventf("Skipping %s\n", folderName)
+						albumDone(AlbumOutcome{Folder: folderName})
+						continue
 					}
+					albumName = name
+				}
+				albumID, ok := albums[albumName]
+				if sharedAlbumID != "" {
+					albumID = sharedAlbumID
+					eventf("Uploading %s into the shared album\n", folderName)
+				} else if !ok {
+					eventf("Creating album: %s\n", albumName)
+					id, err := c.createAlbum(ctx, albumName)
+					brk.observe(ctx, err)
+					if err != nil {
+						eventf("create album %q failed: %v\n", albumName, err)
+						albumDone(AlbumOutcome{Folder: folderName, Album: albumName, Err: fmt.Errorf("create album: %w", err)})
+						continue
+					}
+					albumID = id
+					albums[albumName] = id
+				} else {
+					eventf("Using existing album: %s\n", albumName)
+				}
 
-					fileStart := time.Now()
-					asset, err := c.uploadAsset(ctx, job.path, deviceID, deviceAssetID, created, modified, sum)
-					fileDur := time.Since(fileStart)
-					if err == nil {
-						if merr := moveFileToIgnore(opt.Root, opt.IgnoreDir, folderName, folderPath, job.path); merr != nil {
-							eventf("move failed (%s): %v\n", job.path, merr)
-							if tuiEnabled {
-								tui.Lock()
-								tui.globalMovedFail++
-								tui.Unlock()
-							}
-						}
+				if opt.Hooks.AfterUpload == nil {
+					if _, err := ensureIgnoreAlbumDir(opt.Root, opt.IgnoreDir, folderName); err != nil {
+						eventf("failed to create ignore folder for %s: %v\n", folderName, err)
+						albumDone(AlbumOutcome{Folder: folderName, Album: albumName, AlbumID: albumID, Err: err})
+						continue
 					}
-					results <- uploadResult{idx: job.idx, path: job.path, size: job.size, asset: asset, dur: fileDur, err: err}
 				}
-			}()
-		}
+				cur = &albumState{name: folderName, album: albumName, path: ev.albumPath, id: albumID, start: time.Now()}
 
-		go func() {
-			for i, fp := range files {
-				st, err := os.Stat(fp)
-				if err != nil {
-					results <- uploadResult{idx: i, path: fp, size: 0, err: err}
+			case scanFile:
+				if cur == nil {
+					src.release(ev.file.path)
 					continue
 				}
-				jobs <- uploadJob{idx: i, path: fp, size: st.Size()}
-			}
-			close(jobs)
-			wg.Wait()
-			close(results)
-		}()
+				tracker.queue(cur, ev.file)
+				if cache != nil {
+					cache.keep(ev.file)
+				}
+				tui.Lock()
+				tui.queued++
+				tui.queuedBytes += ev.file.size
+				tui.Unlock()
+				idx++
+				select {
+				case in <- &uploadJob{idx: idx, file: ev.file, album: cur}:
+				case <-ctx.Done():
+					src.release(ev.file.path)
+					return
+				}
 
-		completed := 0
-		uploadedBytesMu := sync.Mutex{}
-		for res := range results {
-			completed++
-			if res.err != nil {
-				uploadErrors++
-				eventf("upload failed (%s): %v\n", res.path, res.err)
-				if tuiEnabled {
-					tui.Lock()
-					tui.globalFailed++
-					tui.Unlock()
+			case scanAlbumEnd:
+				if cur == nil {
+					continue
 				}
-				continue
+				if ev.files > 0 {
+					eventf("Queued %d files (%s) from %s\n", ev.files, formatBytes(ev.bytes), cur.name)
+				}
+				if tracker.end(cur, ev.err) {
+					finalize(cur)
+				}
+				cur = nil
 			}
-			uploadedIDs = append(uploadedIDs, res.asset.ID)
-			if tuiEnabled {
+		}
+	}()
+
+	completed := 0
+	leftInPlace, fullReported := 0, false
+	for res := range results {
+		completed++
+		tui.Lock()
+		tui.albumName = res.album.name
+		tui.done++
+		tui.doneBytes += res.file.size
+		queued, queuedBytes, doneBytes := tui.queued, tui.queuedBytes, tui.doneBytes
+		tui.Unlock()
+
+		if res.err != nil {
+			eventf("upload failed (%s): %v\n", res.file.path, res.err)
+			if isOutOfSpace(res.err) && !fullReported {
+				fullReported = true
+				eventf("The server is out of space: the remaining files are left in place for the next run\n")
+			}
+			tui.Lock()
+			tui.globalFailed++
+			tui.Unlock()
+		} else if res.skipped == skipServerFull || res.skipped == skipAborted {
+			leftInPlace++ // the reason is reported once, not per file
+			tui.Lock()
+			tui.globalSkipped++
+			tui.Unlock()
+		} else if res.skipped != "" {
+			eventf("skipped (%s): %s\n", res.file.path, res.skipped)
+			tui.Lock()
+			tui.globalSkipped++
+			tui.Unlock()
+		} else {
+			if res.moveErr != nil {
+				eventf("move failed (%s): %v\n", res.file.path, res.moveErr)
 				tui.Lock()
-				tui.globalFiles++
-				if strings.Contains(strings.ToLower(res.asset.Status), "duplicate") {
-					tui.globalDup++
-				}
+				tui.globalMovedFail++
 				tui.Unlock()
 			}
+			tui.Lock()
+			tui.globalFiles++
+			tui.globalBytes += res.file.size
+			if isDuplicate(res.asset) {
+				tui.globalDup++
+			}
+			tui.Unlock()
 
-			uploadedBytesMu.Lock()
-			uploadedBytes += res.size
-			elapsed := time.Since(albumStart)
 			if tuiEnabled {
-				tui.Lock()
-				tui.albumDone = completed
-				tui.albumBytes = uploadedBytes
-				tui.globalBytes = tui.globalBytes + res.size
-				tui.Unlock()
 				clearAndPrint(renderLine())
 			} else {
+				elapsed := time.Since(tui.globalStart)
 				logf("    Progress: %d/%d (%s/%s) | avg %s | last %s (%s)\n",
-					completed, len(files), formatBytes(uploadedBytes), formatBytes(totalBytes), formatRate(uploadedBytes, elapsed), formatRate(res.size, res.dur), res.dur.Round(time.Millisecond))
+					completed, queued, formatBytes(doneBytes), formatBytes(queuedBytes), formatRate(doneBytes, elapsed), formatRate(res.file.size, res.dur), res.dur.Round(time.Millisecond))
+				logf("  [%s] %s -> %s (%s)\n", res.album.name, filepath.Base(res.file.path), res.asset.ID, res.asset.Status)
 			}
-
-			if !tuiEnabled {
-				logf("  [%d/%d] %s -> %s (%s)\n", completed, len(files), filepath.Base(res.path), res.asset.ID, res.asset.Status)
-			}
-			uploadedBytesMu.Unlock()
 		}
 
-		if len(uploadedIDs) == 0 {
-			eventf("No uploads succeeded for %s\n", folderName)
-			continue
+		if h := opt.Hooks.FileDone; h != nil {
+			h(FileOutcome{FileInfo: res.file.info(), Asset: res.asset, Skipped: res.skipped, Err: res.err, AfterErr: res.moveErr})
 		}
-
-		if uploadErrors > 0 {
-			eventf("Album %s: %d upload errors (still adding successful assets to album)\n", folderName, uploadErrors)
-		}
-
-		for _, ch := range chunk(uploadedIDs, opt.BatchSize) {
-			if err := c.addAssetsToAlbum(ctx, albumID, ch); err != nil {
-				eventf("add assets to album %s failed: %v\n", folderName, err)
-			}
-		}
-		eventf("Album %s: added %d assets\n", folderName, len(uploadedIDs))
-		if tuiEnabled {
-			tui.Lock()
-			tui.albumName = ""
-			tui.Unlock()
-			fmt.Fprint(os.Stdout, "\r")
+		if tracker.complete(res, opt.DedupeAdd) {
+			finalize(res.album)
 		}
+		src.release(res.file.path)
+	}
+	if tuiEnabled {
+		fmt.Fprint(os.Stdout, "\r")
 	}
 
-	return nil
+	tui.Lock()
+	sum := Summary{
+		Start:      tui.globalStart,
+		End:        time.Now(),
+		Albums:     tui.globalAlbums,
+		Files:      tui.globalFiles,
+		Duplicates: tui.globalDup,
+		Failed:     tui.globalFailed,
+		Skipped:    tui.globalSkipped,
+		MoveFailed: tui.globalMovedFail,
+		Bytes:      tui.globalBytes,
+	}
+	tui.Unlock()
+	if cache != nil {
+		sum.CacheHits, sum.CacheMisses, _ = cache.stats()
+	}
+	if se := pl.full.Load(); se != nil && ctx.Err() == nil {
+		return sum, fmt.Errorf("%w (%v); %d more files left in place", ErrServerFull, se, leftInPlace)
+	}
+	if space.isExceeded() && ctx.Err() == nil {
+		return sum, fmt.Errorf("%w: the upload exceeds the %s it had room for; %d files left in place", ErrServerFull, formatBytes(space.limit), leftInPlace)
+	}
+	return sum, context.Cause(ctx)
 }
 
 // NOTE: This is a simple uploader.
@@ -0,0 +1,282 @@
+package uploader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"immich-uploader/internal/openapi"
+)
+
+// OpenAPI validation (--validate-openapi): a RoundTripper checks every
+// request body and successful response against the schemas of the bundled
+// immich-openapi.json, so hand-written DTOs that drifted from the server's
+// contract show up before they corrupt a run. Each distinct mismatch is
+// logged once; in strict mode the request fails as well.
+
+// Validation modes (Options.ValidateOpenAPI).
+const (
+	ValidateOff    = ""
+	ValidateWarn   = "warn"
+	ValidateStrict = "strict"
+)
+
+// ErrSpecMismatch is returned in strict validation mode for a request or
+// response that doesn't match the bundled OpenAPI spec.
+var ErrSpecMismatch = errors.New("API contract mismatch")
+
+// validateBodyLimit bounds the response bodies that are checked.
+const validateBodyLimit = 64 << 20
+
+// formValueLimit is how much of a multipart field value is checked.
+const formValueLimit = 4 << 10
+
+// validator holds the spec and the mismatches seen in one run.
+type validator struct {
+	spec   *openapi.Spec
+	strict bool
+	base   string // path of the API root, stripped before spec lookup
+	logf   Logf
+
+	mu         sync.Mutex
+	seen       map[string]bool
+	checked    int
+	mismatched int
+	unknown    int
+}
+
+// newValidator returns nil unless opt asks for validation.
+func newValidator(opt Options, logf Logf) (*validator, error) {
+	switch opt.ValidateOpenAPI {
+	case ValidateOff:
+		return nil, nil
+	case ValidateWarn, ValidateStrict:
+	default:
+		return nil, fmt.Errorf("OpenAPI validation %q: expected %s or %s", opt.ValidateOpenAPI, ValidateWarn, ValidateStrict)
+	}
+	spec, err := openapi.Load()
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(strings.TrimRight(opt.BaseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("server URL: %w", err)
+	}
+	return &validator{spec: spec, strict: opt.ValidateOpenAPI == ValidateStrict, base: u.Path, logf: logf, seen: map[string]bool{}}, nil
+}
+
+func (v *validator) wrap(base http.RoundTripper) http.RoundTripper {
+	return &validateTransport{v: v, base: base}
+}
+
+// report logs the mismatches not seen before and, in strict mode, returns
+// them as an error.
+func (v *validator) report(op *openapi.Operation, what string, mismatches []string) error {
+	v.mu.Lock()
+	v.checked++
+	if len(mismatches) > 0 {
+		v.mismatched++
+	}
+	for _, m := range mismatches {
+		key := op.Method + " " + op.Path + " " + what + ": " + m
+		if !v.seen[key] {
+			v.seen[key] = true
+			v.logf("openapi: %s\n", key)
+		}
+	}
+	v.mu.Unlock()
+	if len(mismatches) == 0 || !v.strict {
+		return nil
+	}
+	msg := strings.Join(mismatches[:min(len(mismatches), 3)], "; ")
+	if len(mismatches) > 3 {
+		msg += fmt.Sprintf(" (and %d more)", len(mismatches)-3)
+	}
+	return fmt.Errorf("%w: %s %s %s: %s", ErrSpecMismatch, op.Method, op.Path, what, msg)
+}
+
+// notInSpec notes a request the spec doesn't describe (legacy endpoints).
+func (v *validator) notInSpec(method, path string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.unknown == 0 {
+		v.logf("openapi: %s %s is not in the bundled spec (%s); not checked\n", method, path, v.spec.Version)
+	}
+	v.unknown++
+}
+
+// summary logs the totals of the run.
+func (v *validator) summary() {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	msg := fmt.Sprintf("OpenAPI validation (spec %s): %d requests and responses checked", v.spec.Version, v.checked)
+	if v.mismatched > 0 {
+		msg += fmt.Sprintf(", %d with mismatches (%d distinct)", v.mismatched, len(v.seen))
+	} else {
+		msg += ", all matched"
+	}
+	if v.unknown > 0 {
+		msg += fmt.Sprintf("; %d requests not in the spec", v.unknown)
+	}
+	v.logf("%s\n", msg)
+}
+
+type validateTransport struct {
+	v    *validator
+	base http.RoundTripper
+}
+
+func (t *validateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	v := t.v
+	path, ok := strings.CutPrefix(req.URL.Path, v.base)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	op := v.spec.Find(req.Method, path)
+
+	var form *formSniffer
+	if op != nil {
+		ctype := req.Header.Get("Content-Type")
+		mt, params, _ := mime.ParseMediaType(ctype)
+		switch {
+		case strings.HasPrefix(mt, "multipart/") && req.Body != nil:
+			form = newFormSniffer(req.Body, params["boundary"])
+			req = req.Clone(req.Context())
+			req.Body = form
+		default:
+			var body []byte
+			if req.Body != nil && req.Body != http.NoBody {
+				b, err := io.ReadAll(req.Body)
+				_ = req.Body.Close()
+				if err != nil {
+					return nil, err
+				}
+				body = b
+				req = req.Clone(req.Context())
+				req.Body = io.NopCloser(bytes.NewReader(b))
+				req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(b)), nil }
+			}
+			if err := v.report(op, "request", op.CheckRequest(ctype, body)); err != nil {
+				return nil, err // not sent
+			}
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if form != nil {
+		if fields, complete := form.result(); complete && err == nil {
+			if ferr := v.report(op, "request", op.CheckForm(fields)); ferr != nil {
+				resp.Body.Close()
+				return nil, ferr // sent, but the result can't be trusted
+			}
+		}
+	}
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp, err
+	}
+	if op == nil {
+		v.notInSpec(req.Method, path)
+		return resp, nil
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, validateBodyLimit+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(b) > validateBodyLimit {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(b), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	if err := v.report(op, "response", op.CheckResponse(resp.StatusCode, resp.Header.Get("Content-Type"), b)); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// formSniffer passes a multipart body through while a goroutine parses a
+// copy of it, keeping the field names and values and skipping file data.
+type formSniffer struct {
+	rc   io.ReadCloser
+	pw   *io.PipeWriter
+	done chan struct{}
+
+	once     sync.Once
+	fields   []openapi.FormField
+	complete bool // the whole body was parsed
+}
+
+func newFormSniffer(rc io.ReadCloser, boundary string) *formSniffer {
+	pr, pw := io.Pipe()
+	s := &formSniffer{rc: rc, pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		defer io.Copy(io.Discard, pr) // never block the request
+		mr := multipart.NewReader(pr, boundary)
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				s.complete = true
+				return
+			}
+			if err != nil {
+				return
+			}
+			f := openapi.FormField{Name: part.FormName(), File: part.FileName() != ""}
+			if !f.File {
+				b, _ := io.ReadAll(io.LimitReader(part, formValueLimit))
+				f.Value = string(b)
+			}
+			if _, err := io.Copy(io.Discard, part); err != nil {
+				return
+			}
+			s.fields = append(s.fields, f)
+		}
+	}()
+	return s
+}
+
+func (s *formSniffer) Read(p []byte) (int, error) {
+	n, err := s.rc.Read(p)
+	if n > 0 {
+		_, _ = s.pw.Write(p[:n]) // fails only once the parser gave up
+	}
+	if err == io.EOF {
+		_ = s.pw.Close()
+	}
+	return n, err
+}
+
+func (s *formSniffer) Close() error {
+	s.stop()
+	return s.rc.Close()
+}
+
+func (s *formSniffer) stop() {
+	s.once.Do(func() { _ = s.pw.CloseWithError(io.ErrUnexpectedEOF) })
+}
+
+// result waits for the parser. The body is cut off first: a request that
+// got its response before the body was sent was not parsed completely.
+func (s *formSniffer) result() ([]openapi.FormField, bool) {
+	s.stop()
+	<-s.done
+	return s.fields, s.complete
+}
@@ -0,0 +1,295 @@
+package uploader
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"immich-uploader/immich"
+)
+
+// VerifySummary counts what Verify found.
+type VerifySummary struct {
+	Albums     int // album folders under the ignore folder
+	Files      int // media files in them
+	Missing    int // not on the server
+	NotInAlbum int // on the server but not in the folder's album
+	Failed     int // could not be hashed or checked
+}
+
+func (s VerifySummary) String() string {
+	return fmt.Sprintf("%d files in %d albums: %d missing on the server, %d not in their album, %d not checked",
+		s.Files, s.Albums, s.Missing, s.NotInAlbum, s.Failed)
+}
+
+func (s VerifySummary) ok() bool {
+	return s.Missing == 0 && s.NotInAlbum == 0 && s.Failed == 0
+}
+
+// verifyFile is one file under the ignore folder and what the server says
+// about it.
+type verifyFile struct {
+	rel     string // relative to the ignore folder, for output
+	album   string
+	entry   fileEntry
+	sum     string
+	assetID string
+	err     error
+}
+
+// Verify checks that the files already moved into the ignore folder are
+// really on the server: each one is hashed and looked up with
+// bulk-upload-check, and (with DedupeAdd, when duplicates are added to the
+// album too) its asset must be in the album named after its folder. Nothing
+// is uploaded or moved; every problem is logged and Verify fails if there
+// was any.
+func Verify(ctx context.Context, opt Options, logf Logf) (_ VerifySummary, err error) {
+	if logf == nil {
+		logf = func(format string, args ...any) { fmt.Fprintf(os.Stdout, format, args...) }
+	}
+	if opt.SharedLink != "" {
+		return VerifySummary{}, errors.New("verify: a shared link can't look up assets; use an API key or email and password")
+	}
+	if opt.APIKey == "" && (opt.Email == "" || opt.Password == "") {
+		return VerifySummary{}, fmt.Errorf("missing API key (or email and password)")
+	}
+	if opt.Root == "" {
+		return VerifySummary{}, fmt.Errorf("missing root")
+	}
+	secrets := append([]string{opt.APIKey, opt.Password}, headerSecrets(opt.Headers)...)
+	defer func() { err = immich.RedactError(err, secrets...) }()
+	logf = redactLogf(logf, secrets...)
+
+	files, sum, err := verifyFiles(opt)
+	if err != nil {
+		return sum, err
+	}
+	if sum.Files == 0 {
+		logf("Nothing to verify in %s\n", filepath.Join(opt.Root, opt.IgnoreDir))
+		return sum, nil
+	}
+
+	trace := newTracer(opt, logf, secrets)
+	defer func() {
+		if err := trace.writeHAR(); err != nil {
+			logf("%v\n", err)
+		}
+	}()
+	check, err := newValidator(opt, logf)
+	if err != nil {
+		return sum, err
+	}
+	defer check.summary()
+	hc, _, err := newHTTPClients(opt, trace, check)
+	if err != nil {
+		return sum, err
+	}
+	c := newClient(strings.TrimRight(opt.BaseURL, "/"), immich.APIKey(opt.APIKey), hc, nil)
+	if opt.APIKey == "" {
+		c.Auth = &immich.Session{Email: opt.Email, Password: opt.Password}
+	}
+	if err := c.checkServer(ctx, logf); err != nil {
+		return sum, err
+	}
+	if _, ok := c.Auth.(*immich.Session); ok {
+		if _, err := c.login(ctx); err != nil {
+			return sum, err
+		}
+		defer func() {
+			if err := c.logout(); err != nil {
+				logf("logout: %v\n", err)
+			}
+		}()
+	}
+
+	var cache *checksumCache
+	if !opt.NoChecksumCache {
+		// Not pruned: the cache also holds the files still waiting for upload.
+		cache = openChecksumCache(opt.ChecksumCache, opt.RebuildChecksumCache)
+		defer func() {
+			if err := cache.save(); err != nil {
+				logf("save checksum cache: %v\n", err)
+			}
+		}()
+	}
+	hashFiles(ctx, files, cmp.Or(opt.HashWorkers, 1), cache)
+	if err := ctx.Err(); err != nil {
+		return sum, err
+	}
+	if err := c.lookupFiles(ctx, files); err != nil {
+		return sum, err
+	}
+
+	var inAlbum map[string]map[string]bool // album name -> asset IDs
+	if opt.DedupeAdd {
+		if inAlbum, err = c.albumAssets(ctx, files); err != nil {
+			return sum, fmt.Errorf("failed to list albums: %w", err)
+		}
+	}
+
+	for _, f := range files {
+		switch {
+		case f.err != nil:
+			sum.Failed++
+			logf("error: %s: %v\n", f.rel, f.err)
+		case f.assetID == "":
+			sum.Missing++
+			logf("missing: %s\n", f.rel)
+		case inAlbum != nil && !inAlbum[f.album][f.assetID]:
+			sum.NotInAlbum++
+			logf("not in album %q: %s\n", f.album, f.rel)
+		}
+	}
+	logf("Verified %v\n", sum)
+	if !sum.ok() {
+		return sum, fmt.Errorf("verify: %d of %d files are not on the server or not in their album",
+			sum.Missing+sum.NotInAlbum+sum.Failed, sum.Files)
+	}
+	return sum, nil
+}
+
+// verifyFiles lists the media files under the ignore folder, whose first level
+// are the album folders, sorted by path.
+func verifyFiles(opt Options) ([]*verifyFile, VerifySummary, error) {
+	var sum VerifySummary
+	dir := filepath.Join(opt.Root, opt.IgnoreDir)
+	albums, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, sum, nil
+	}
+	if err != nil {
+		return nil, sum, err
+	}
+	var files []*verifyFile
+	for _, a := range albums {
+		if !a.IsDir() || strings.HasPrefix(a.Name(), ".") {
+			continue
+		}
+		sum.Albums++
+		albumPath := filepath.Join(dir, a.Name())
+		err := filepath.WalkDir(albumPath, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			name := d.Name()
+			if d.IsDir() {
+				if path != albumPath && (strings.HasPrefix(name, ".") || !opt.Deep) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasPrefix(name, ".") || !IsMediaFile(name) {
+				return nil
+			}
+			st, err := os.Stat(path)
+			if err != nil || !st.Mode().IsRegular() {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, &verifyFile{
+				rel:   filepath.ToSlash(rel),
+				album: a.Name(),
+				entry: newFileEntry(a.Name(), albumPath, path, st),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, sum, err
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].rel < files[j].rel })
+	sum.Files = len(files)
+	return files, sum, nil
+}
+
+// hashFiles computes the sha1 of every file with n workers.
+func hashFiles(ctx context.Context, files []*verifyFile, n int, cache *checksumCache) {
+	next := make(chan *verifyFile)
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range next {
+				if cache != nil {
+					f.sum, f.err = cache.checksum(f.entry)
+				} else {
+					f.sum, f.err = sha1File(f.entry.path)
+				}
+			}
+		}()
+	}
+	for _, f := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		next <- f
+	}
+	close(next)
+	wg.Wait()
+}
+
+// lookupFiles sets the asset ID of every hashed file the server has.
+func (c *client) lookupFiles(ctx context.Context, files []*verifyFile) error {
+	for start := 0; start < len(files); start += preflightBatchSize {
+		batch := files[start:min(start+preflightBatchSize, len(files))]
+		items := make([]immich.UploadCheck, 0, len(batch))
+		for i, f := range batch {
+			if f.err == nil {
+				items = append(items, immich.UploadCheck{ID: strconv.Itoa(i), Checksum: f.sum})
+			}
+		}
+		if len(items) == 0 {
+			continue
+		}
+		results, err := c.BulkUploadCheck(ctx, items)
+		if err != nil {
+			return fmt.Errorf("bulk upload check: %w", err)
+		}
+		for _, r := range results {
+			i, err := strconv.Atoi(r.ID)
+			if err != nil || i < 0 || i >= len(batch) || r.Action != "reject" || r.Reason != "duplicate" {
+				continue
+			}
+			batch[i].assetID = r.AssetID
+		}
+	}
+	return nil
+}
+
+// albumAssets returns the asset IDs of the albums the files belong to. An
+// album that doesn't exist has no assets.
+func (c *client) albumAssets(ctx context.Context, files []*verifyFile) (map[string]map[string]bool, error) {
+	ids, err := c.getAllAlbums(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]map[string]bool{}
+	for _, f := range files {
+		if _, ok := out[f.album]; ok {
+			continue
+		}
+		assets := map[string]bool{}
+		out[f.album] = assets
+		id, ok := ids[f.album]
+		if !ok {
+			continue
+		}
+		album, err := c.GetAlbum(ctx, id, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range album.Assets {
+			assets[a.ID] = true
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,350 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	defaultSettleTime    = 10 * time.Second
+	defaultWatchRescan   = 10 * time.Minute
+	watchSettleCheckTick = time.Second
+)
+
+// Watch monitors opt.Root and uploads new files once they have stopped
+// changing for opt.SettleTime, using the same album/upload/move path as Run.
+// A full rescan every opt.WatchRescan picks up anything fsnotify missed.
+// It runs until ctx is cancelled.
+func Watch(ctx context.Context, opt Options, logf Logf) error {
+	w := newWatcher(opt)
+	_, err := run(ctx, opt, logf, w)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// watcher is an eventSource that turns filesystem notifications into batches
+// of settled files, one album at a time.
+type watcher struct {
+	root      string
+	ignoreDir string
+	deep      bool
+	settle    time.Duration
+	rescan    time.Duration
+	sc        *scanner
+
+	mu       sync.Mutex
+	pending  map[string]*pendingFile
+	inFlight map[string]bool
+	scanning bool
+}
+
+// pendingFile is a candidate that is waiting to stop changing.
+type pendingFile struct {
+	album     string
+	albumPath string
+	size      int64
+	modTime   time.Time
+	changed   time.Time // last time size or mtime was seen changing (or first seen)
+}
+
+func newWatcher(opt Options) *watcher {
+	settle := opt.SettleTime
+	if settle <= 0 {
+		settle = defaultSettleTime
+	}
+	rescan := opt.WatchRescan
+	if rescan <= 0 {
+		rescan = defaultWatchRescan
+	}
+	sc := newScanner(opt)
+	sc.smallestFirst = false
+	return &watcher{
+		root:      opt.Root,
+		ignoreDir: opt.IgnoreDir,
+		deep:      opt.Deep,
+		settle:    settle,
+		rescan:    rescan,
+		sc:        sc,
+		pending:   map[string]*pendingFile{},
+		inFlight:  map[string]bool{},
+	}
+}
+
+func (w *watcher) release(path string) {
+	w.mu.Lock()
+	delete(w.inFlight, path)
+	w.mu.Unlock()
+}
+
+func (w *watcher) events(ctx context.Context, logf Logf) (<-chan scanEvent, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := fsw.Add(w.root); err != nil {
+		_ = fsw.Close()
+		return nil, err
+	}
+	entries, err := os.ReadDir(w.root)
+	if err != nil {
+		_ = fsw.Close()
+		return nil, err
+	}
+	// watchTree also queues the files that are already there
+	for _, e := range entries {
+		if e.IsDir() && e.Name() != w.ignoreDir {
+			w.watchTree(fsw, filepath.Join(w.root, e.Name()), logf)
+		}
+	}
+	logf("Watching %s for new files (settle %s, rescan every %s)\n", w.root, w.settle, w.rescan)
+
+	out := make(chan scanEvent)
+	go func() {
+		defer close(out)
+		defer fsw.Close()
+
+		settleTick := time.NewTicker(watchSettleCheckTick)
+		defer settleTick.Stop()
+		rescanTick := time.NewTicker(w.rescan)
+		defer rescanTick.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-fsw.Events:
+				if !ok {
+					return
+				}
+				w.handle(fsw, ev, logf)
+			case err, ok := <-fsw.Errors:
+				if !ok {
+					return
+				}
+				logf("watch: %v\n", err)
+				if errors.Is(err, fsnotify.ErrEventOverflow) {
+					go w.fullScan(ctx, logf)
+				}
+			case <-rescanTick.C:
+				go w.fullScan(ctx, logf)
+			case <-settleTick.C:
+				if !w.emitSettled(ctx, out) {
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// album returns the album folder for path, or ok=false if path is not inside
+// an album folder (or is too deep when not in deep mode).
+func (w *watcher) album(path string) (name, albumPath string, ok bool) {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", "", false
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	if parts[0] == w.ignoreDir {
+		return "", "", false
+	}
+	return parts[0], filepath.Join(w.root, parts[0]), true
+}
+
+// watchTree adds watches for dir and, in deep mode, its subdirectories, and
+// queues media files already inside (they may have landed before the watch).
+func (w *watcher) watchTree(fsw *fsnotify.Watcher, dir string, logf Logf) {
+	album, albumPath, ok := w.album(dir)
+	if !ok {
+		return
+	}
+	_ = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != albumPath && !w.deep {
+				return filepath.SkipDir
+			}
+			if err := fsw.Add(path); err != nil {
+				logf("watch %s: %v\n", path, err)
+			}
+			return nil
+		}
+		w.touch(album, albumPath, path)
+		return nil
+	})
+}
+
+func (w *watcher) handle(fsw *fsnotify.Watcher, ev fsnotify.Event, logf Logf) {
+	if !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Write) {
+		return
+	}
+	album, albumPath, ok := w.album(ev.Name)
+	if !ok {
+		return
+	}
+	st, err := os.Stat(ev.Name)
+	if err != nil {
+		return
+	}
+	if st.IsDir() {
+		if ev.Has(fsnotify.Create) && (w.deep || ev.Name == albumPath) {
+			w.watchTree(fsw, ev.Name, logf)
+		}
+		return
+	}
+	if ev.Name == albumPath {
+		return // a file directly under the root, not in an album folder
+	}
+	if !w.deep && filepath.Dir(ev.Name) != albumPath {
+		return
+	}
+	w.touch(album, albumPath, ev.Name)
+}
+
+// touch (re)starts the settle timer for a media file.
+func (w *watcher) touch(album, albumPath, path string) {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") || (w.sc.filter == nil && !IsMediaFile(name)) {
+		return
+	}
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.inFlight[path] {
+		return
+	}
+	if p, ok := w.pending[path]; ok {
+		p.changed = now
+		return
+	}
+	w.pending[path] = &pendingFile{album: album, albumPath: albumPath, size: -1, changed: now}
+}
+
+// fullScan re-lists the whole root as a safety net for missed notifications.
+func (w *watcher) fullScan(ctx context.Context, logf Logf) {
+	w.mu.Lock()
+	if w.scanning {
+		w.mu.Unlock()
+		return
+	}
+	w.scanning = true
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		w.scanning = false
+		w.mu.Unlock()
+	}()
+
+	events, err := w.sc.scan(ctx)
+	if err != nil {
+		logf("rescan: %v\n", err)
+		return
+	}
+	for ev := range events {
+		switch ev.kind {
+		case scanFile:
+			w.mu.Lock()
+			if _, ok := w.pending[ev.file.path]; !ok && !w.inFlight[ev.file.path] {
+				w.pending[ev.file.path] = &pendingFile{album: ev.album, albumPath: ev.albumPath, size: -1, changed: time.Now()}
+			}
+			w.mu.Unlock()
+		case scanAlbumEnd:
+			if ev.err != nil {
+				logf("rescan %s: %v\n", ev.album, ev.err)
+			}
+		}
+	}
+}
+
+// emitSettled re-stats pending files and sends the ones that have not changed
+// for the settle period as one batch per album.
+func (w *watcher) emitSettled(ctx context.Context, out chan<- scanEvent) bool {
+	w.mu.Lock()
+	snapshot := make([]string, 0, len(w.pending))
+	for path := range w.pending {
+		snapshot = append(snapshot, path)
+	}
+	w.mu.Unlock()
+
+	// stat outside the lock; touch/release may run concurrently
+	now := time.Now()
+	stats := make(map[string]os.FileInfo, len(snapshot))
+	for _, path := range snapshot {
+		if st, err := os.Stat(path); err == nil && st.Mode().IsRegular() {
+			stats[path] = st
+		}
+	}
+
+	batches := map[string][]fileEntry{}
+	paths := map[string]string{}
+	w.mu.Lock()
+	for _, path := range snapshot {
+		p, ok := w.pending[path]
+		if !ok {
+			continue
+		}
+		st, ok := stats[path]
+		if !ok {
+			delete(w.pending, path)
+			continue
+		}
+		if st.Size() != p.size || !st.ModTime().Equal(p.modTime) {
+			p.size, p.modTime, p.changed = st.Size(), st.ModTime(), now
+			continue
+		}
+		if now.Sub(p.changed) < w.settle {
+			continue
+		}
+		delete(w.pending, path)
+		e := newFileEntry(p.album, p.albumPath, path, st)
+		if !w.sc.wants(e) {
+			continue
+		}
+		w.inFlight[path] = true
+		batches[p.album] = append(batches[p.album], e)
+		paths[p.album] = p.albumPath
+	}
+	w.mu.Unlock()
+
+	albums := make([]string, 0, len(batches))
+	for a := range batches {
+		albums = append(albums, a)
+	}
+	sort.Strings(albums)
+
+	if len(albums) > 0 && !w.sc.send(ctx, out, scanEvent{kind: scanBatch}) {
+		return false
+	}
+	for _, a := range albums {
+		files := batches[a]
+		sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+		var bytes int64
+		for _, f := range files {
+			bytes += f.size
+		}
+		if !w.sc.send(ctx, out, scanEvent{kind: scanAlbumStart, album: a, albumPath: paths[a]}) {
+			return false
+		}
+		for _, f := range files {
+			if !w.sc.send(ctx, out, scanEvent{kind: scanFile, album: a, albumPath: paths[a], file: f}) {
+				return false
+			}
+		}
+		if !w.sc.send(ctx, out, scanEvent{kind: scanAlbumEnd, album: a, albumPath: paths[a], files: len(files), bytes: bytes}) {
+			return false
+		}
+	}
+	return true
+}
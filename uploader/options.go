@@ -0,0 +1,209 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	engine "immich-uploader/internal/uploader"
+)
+
+// Option configures an Uploader. Unset options keep the defaults of the
+// immich-uploader command.
+type Option func(*Uploader)
+
+// fail records the first invalid option; New returns it.
+func (u *Uploader) fail(err error) {
+	if u.err == nil {
+		u.err = err
+	}
+}
+
+// WithAPIKey authenticates with an API key.
+func WithAPIKey(key string) Option {
+	return func(u *Uploader) { u.opt.APIKey = key }
+}
+
+// WithLogin logs in with email and password for the duration of each
+// upload, renewing the session if it expires.
+func WithLogin(email, password string) Option {
+	return func(u *Uploader) { u.opt.Email, u.opt.Password = email, password }
+}
+
+// WithSharedLink uploads through a shared link (a key or a share URL with
+// "allow upload"). Every album folder goes into the link's album; albums are
+// not created and the album resolver is only used to skip folders.
+func WithSharedLink(link, password string) Option {
+	return func(u *Uploader) { u.opt.SharedLink, u.opt.SharedLinkPassword = link, password }
+}
+
+// WithWorkers sets how many files are uploaded in parallel (default 4).
+func WithWorkers(n int) Option {
+	return func(u *Uploader) {
+		if n < 1 {
+			u.fail(errors.New("workers must be at least 1"))
+			return
+		}
+		u.opt.Workers = n
+	}
+}
+
+// WithBatchSize sets how many assets are added to an album per request
+// (default 200).
+func WithBatchSize(n int) Option {
+	return func(u *Uploader) {
+		if n < 1 {
+			u.fail(errors.New("batch size must be at least 1"))
+			return
+		}
+		u.opt.BatchSize = n
+	}
+}
+
+// WithIgnoreDir sets the folder under the root that uploaded files are moved
+// into (default "ignore"). It is not used with WithAfterUpload.
+func WithIgnoreDir(name string) Option {
+	return func(u *Uploader) { u.opt.IgnoreDir = name }
+}
+
+// WithChecksum enables or disables SHA-1 checksums (default on). Without
+// them the server can't dedupe uploads and the duplicate preflight is
+// skipped.
+func WithChecksum(on bool) Option {
+	return func(u *Uploader) { u.opt.Checksum = on }
+}
+
+// WithChecksumCache sets the path of the persistent checksum cache. An empty
+// path disables the cache.
+func WithChecksumCache(path string) Option {
+	return func(u *Uploader) { u.opt.ChecksumCache, u.opt.NoChecksumCache = path, path == "" }
+}
+
+// WithRateLimit caps upload bandwidth in bytes/s across all workers
+// (0 = unlimited).
+func WithRateLimit(bytesPerSec int64) Option {
+	return func(u *Uploader) { u.opt.RateLimit = bytesPerSec }
+}
+
+// WithTimeout bounds each API call, and is the shortest deadline an upload
+// gets (default 5m).
+func WithTimeout(d time.Duration) Option {
+	return func(u *Uploader) { u.opt.Timeout = d }
+}
+
+// WithSettleTime skips files modified within d, so files still being written
+// are left for a later upload (default 10s).
+func WithSettleTime(d time.Duration) Option {
+	return func(u *Uploader) { u.opt.SettleTime = d }
+}
+
+// WithLockWait sets how long Upload waits for another run on the same root
+// before failing with ErrLocked (default 0: fail immediately).
+func WithLockWait(d time.Duration) Option {
+	return func(u *Uploader) { u.opt.LockWait = d }
+}
+
+// WithDedupeAdd sets whether files the server already has are still added to
+// the album (default true).
+func WithDedupeAdd(on bool) Option {
+	return func(u *Uploader) { u.opt.DedupeAdd = on }
+}
+
+// Space check modes (WithSpaceCheck).
+const (
+	SpaceCheckAbort = engine.SpaceCheckAbort
+	SpaceCheckWarn  = engine.SpaceCheckWarn
+	SpaceCheckOff   = engine.SpaceCheckOff
+)
+
+// WithSpaceCheck sets what happens when the files left for upload (after the
+// duplicate preflight) don't fit in the server's free space or the user's
+// quota. Files are counted as they reach the upload stage, not summed up
+// front, so SpaceCheckAbort (default) uploads the files that fit, starts none
+// after the first that doesn't and fails with ErrServerFull, leaving the rest
+// in place for a later run; SpaceCheckWarn logs and uploads until the server
+// is full; SpaceCheckOff skips the check.
+func WithSpaceCheck(mode string) Option {
+	return func(u *Uploader) {
+		switch mode {
+		case SpaceCheckAbort, SpaceCheckWarn, SpaceCheckOff:
+			u.opt.SpaceCheck = mode
+		default:
+			u.fail(errors.New("space check: expected " + SpaceCheckAbort + ", " + SpaceCheckWarn + " or " + SpaceCheckOff))
+		}
+	}
+}
+
+// WithValidation checks every request and response against the bundled
+// Immich OpenAPI spec; strict also fails the upload with ErrSpecMismatch on
+// the first mismatch. Meant for tests.
+func WithValidation(strict bool) Option {
+	return func(u *Uploader) {
+		u.opt.ValidateOpenAPI = engine.ValidateWarn
+		if strict {
+			u.opt.ValidateOpenAPI = engine.ValidateStrict
+		}
+	}
+}
+
+// WithHeader sends an extra header with every request, e.g. the
+// CF-Access-Client-Id of a Cloudflare Access service token. Header values
+// are redacted from log output.
+func WithHeader(key, value string) Option {
+	return func(u *Uploader) {
+		if u.opt.Headers == nil {
+			u.opt.Headers = http.Header{}
+		}
+		u.opt.Headers.Add(key, value)
+	}
+}
+
+// WithCACert trusts the PEM bundle at path in addition to the system roots.
+func WithCACert(path string) Option {
+	return func(u *Uploader) { u.opt.CACert = path }
+}
+
+// WithClientCert presents the PEM certificate and key for mutual TLS.
+func WithClientCert(certPath, keyPath string) Option {
+	return func(u *Uploader) { u.opt.ClientCert, u.opt.ClientKey = certPath, keyPath }
+}
+
+// WithProxy sends requests through an http://, https:// or socks5:// proxy
+// (default: the HTTPS_PROXY / HTTP_PROXY / NO_PROXY environment variables).
+func WithProxy(proxyURL string) Option {
+	return func(u *Uploader) { u.opt.Proxy = proxyURL }
+}
+
+// WithLogger receives the progress lines the command prints (default:
+// discarded). Credentials are redacted.
+func WithLogger(logf func(format string, args ...any)) Option {
+	return func(u *Uploader) {
+		if logf != nil {
+			u.logf = logf
+		}
+	}
+}
+
+// WithFilter decides which files are uploaded, instead of the default list
+// of photo and video extensions (see IsMedia). Hidden files are never
+// uploaded. It may be called concurrently.
+func WithFilter(filter func(File) bool) Option {
+	return func(u *Uploader) { u.hooks.filter = filter }
+}
+
+// WithAlbumResolver names the album each album folder (its name under the
+// root) goes into, instead of the folder name. Returning "" skips the
+// folder; an error skips it and is reported as an *AlbumError.
+func WithAlbumResolver(resolve func(ctx context.Context, folder string) (string, error)) Option {
+	return func(u *Uploader) { u.hooks.album = resolve }
+}
+
+// WithAfterUpload replaces moving each file into the ignore folder once the
+// server has it. The action is responsible for keeping the next Upload from
+// seeing the file again (deleting, moving or recording it). An error is
+// reported as a FileError with After set; the file stays uploaded. It may be
+// called concurrently.
+func WithAfterUpload(action func(ctx context.Context, f File, asset Asset) error) Option {
+	return func(u *Uploader) { u.hooks.afterUpload = action }
+}
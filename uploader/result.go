@@ -0,0 +1,164 @@
+package uploader
+
+import (
+	"fmt"
+	"time"
+
+	"immich-uploader/immich"
+	engine "immich-uploader/internal/uploader"
+)
+
+// File is a media file found in an album folder.
+type File struct {
+	Path    string
+	Folder  string // album folder, relative to the root
+	Size    int64
+	ModTime time.Time
+}
+
+func file(f engine.FileInfo) File {
+	return File{Path: f.Path, Folder: f.Folder, Size: f.Size, ModTime: f.ModTime}
+}
+
+// Asset is the server's copy of an uploaded file.
+type Asset struct {
+	ID        string
+	Duplicate bool // the server already had the file
+}
+
+// FileStatus is what happened to a file.
+type FileStatus int
+
+const (
+	Uploaded  FileStatus = iota
+	Duplicate            // the server already had it
+	Skipped              // left in place for a later upload
+	Failed
+)
+
+func (s FileStatus) String() string {
+	switch s {
+	case Uploaded:
+		return "uploaded"
+	case Duplicate:
+		return "duplicate"
+	case Skipped:
+		return "skipped"
+	case Failed:
+		return "failed"
+	}
+	return fmt.Sprintf("FileStatus(%d)", int(s))
+}
+
+// FileResult is the outcome of one file.
+type FileResult struct {
+	Path    string
+	Size    int64
+	Status  FileStatus
+	AssetID string // set once the server has the file, even if it then failed
+	Reason  string // why the file was skipped
+	Err     error  // why the upload failed
+	// AfterErr is the error of moving the file into the ignore folder (or of
+	// the WithAfterUpload action). The file is on the server regardless.
+	AfterErr error
+}
+
+func fileResult(o engine.FileOutcome) FileResult {
+	f := FileResult{
+		Path:     o.Path,
+		Size:     o.Size,
+		AssetID:  o.Asset.ID,
+		Reason:   o.Skipped,
+		Err:      o.Err,
+		AfterErr: o.AfterErr,
+	}
+	switch {
+	case o.Err != nil:
+		f.Status = Failed
+	case o.Skipped != "":
+		f.Status = Skipped
+	case o.Asset.Status == immich.StatusDuplicate:
+		f.Status = Duplicate
+	default:
+		f.Status = Uploaded
+	}
+	return f
+}
+
+// AlbumResult is the outcome of one album folder.
+type AlbumResult struct {
+	Folder  string
+	Album   string // Immich album name; empty if the folder was skipped
+	AlbumID string
+	Files   []FileResult // sorted by path
+	Added   int          // assets added to the album
+	Err     error        // resolving or creating the album, or adding assets to it
+}
+
+// Result is what an Upload did. Albums are sorted by folder.
+type Result struct {
+	Start, End time.Time
+	Albums     []AlbumResult
+
+	Uploaded    int
+	Duplicates  int
+	Skipped     int
+	Failed      int
+	AfterFailed int
+	Bytes       int64 // of the files uploaded or already on the server
+
+	// CacheHits and CacheMisses count the checksum cache lookups.
+	CacheHits   int64
+	CacheMisses int64
+}
+
+func (r *Result) count(f FileResult) {
+	switch f.Status {
+	case Uploaded:
+		r.Uploaded++
+	case Duplicate:
+		r.Duplicates++
+	case Skipped:
+		r.Skipped++
+	case Failed:
+		r.Failed++
+	}
+	if f.AfterErr != nil {
+		r.AfterFailed++
+	}
+}
+
+// AlbumError is an album folder that could not be (fully) uploaded: its
+// album could not be resolved or created, or assets could not be added.
+type AlbumError struct {
+	Folder string
+	Album  string
+	Err    error
+}
+
+func (e *AlbumError) Error() string {
+	if e.Album == "" || e.Album == e.Folder {
+		return fmt.Sprintf("album %s: %v", e.Folder, e.Err)
+	}
+	return fmt.Sprintf("album %s (%s): %v", e.Folder, e.Album, e.Err)
+}
+
+func (e *AlbumError) Unwrap() error { return e.Err }
+
+// FileError is a file that failed to upload or, if After is set, whose
+// post-upload move (or WithAfterUpload action) failed.
+type FileError struct {
+	Path  string
+	Album string
+	After bool
+	Err   error
+}
+
+func (e *FileError) Error() string {
+	if e.After {
+		return fmt.Sprintf("%s: after upload: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error { return e.Err }
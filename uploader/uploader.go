@@ -0,0 +1,177 @@
+// Package uploader uploads album folders to an Immich server, for Go
+// programs that embed it instead of running the immich-uploader command.
+//
+// Every subfolder of the root is an album: its media files are uploaded,
+// added to the album of the same name (created if needed) and moved into the
+// ignore folder so the next run skips them.
+//
+//	u, err := uploader.New("https://photos.example.com/api", uploader.WithAPIKey(key))
+//	if err != nil {
+//		return err
+//	}
+//	res, err := u.Upload(ctx, "/srv/camera-roll")
+//
+// Upload returns a Result even when it fails. Its error joins the error that
+// stopped the run (if any) with an *AlbumError per failed album and a
+// *FileError per failed file; errors.As and errors.Is see through the join.
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"immich-uploader/immich"
+	"immich-uploader/internal/config"
+	engine "immich-uploader/internal/uploader"
+)
+
+// Errors by kind. Errors returned by Upload (including the Err of every
+// AlbumError and FileError) match these with errors.Is.
+var (
+	ErrAuth         = engine.ErrAuth         // 401: key revoked, session expired
+	ErrPermission   = engine.ErrPermission   // 403: key lacks a permission
+	ErrNotFound     = engine.ErrNotFound     // 404: album or asset gone
+	ErrServer       = engine.ErrServer       // 5xx
+	ErrNetwork      = engine.ErrNetwork      // connection refused, reset, timeout
+	ErrServerFull   = engine.ErrServerFull   // out of disk space or quota
+	ErrLocked       = engine.ErrLocked       // another run is uploading the root
+	ErrSpecMismatch = engine.ErrSpecMismatch // see WithValidation
+)
+
+// Uploader uploads album folders to one server. It is safe for concurrent
+// use, but runs on the same root wait for each other (see WithLockWait).
+type Uploader struct {
+	opt   engine.Options
+	logf  func(format string, args ...any)
+	hooks hooks
+	err   error // first invalid option
+}
+
+// hooks are the Option-provided callbacks, wrapped into engine.Hooks per
+// run.
+type hooks struct {
+	filter      func(File) bool
+	album       func(ctx context.Context, folder string) (string, error)
+	afterUpload func(ctx context.Context, f File, asset Asset) error
+}
+
+// New returns an Uploader for the Immich API at baseURL (including the /api
+// suffix). One of WithAPIKey, WithLogin and WithSharedLink is required.
+func New(baseURL string, opts ...Option) (*Uploader, error) {
+	opt, err := config.Defaults().Options()
+	if err != nil {
+		return nil, err
+	}
+	opt.BaseURL = baseURL
+	// The terminal belongs to the embedding program.
+	opt.TUI, opt.TUIAuto, opt.NoANSI = false, false, true
+
+	u := &Uploader{opt: opt, logf: func(string, ...any) {}}
+	for _, o := range opts {
+		o(u)
+	}
+	if u.err != nil {
+		return nil, u.err
+	}
+	if p, err := url.Parse(baseURL); err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		return nil, fmt.Errorf("base URL %q: expected http(s)://host[:port]/api", baseURL)
+	}
+	o := u.opt
+	if o.APIKey == "" && (o.Email == "" || o.Password == "") && o.SharedLink == "" {
+		return nil, errors.New("missing credentials: use WithAPIKey, WithLogin or WithSharedLink")
+	}
+	return u, nil
+}
+
+// Upload uploads every album folder under root once and reports what it did.
+// Cancelling ctx stops the run after the uploads in flight; files not
+// uploaded yet are left in place and reported as skipped.
+func (u *Uploader) Upload(ctx context.Context, root string) (*Result, error) {
+	opt := u.opt
+	opt.Root = root
+
+	var (
+		mu     sync.Mutex
+		files  = map[string][]FileResult{} // by album folder
+		albums []AlbumResult
+		errs   []error
+	)
+	opt.Hooks = u.hooks.wrap()
+	// FileDone and AlbumDone are called from the run's feeder and result
+	// goroutines.
+	opt.Hooks.FileDone = func(o engine.FileOutcome) {
+		f := fileResult(o)
+		mu.Lock()
+		defer mu.Unlock()
+		files[o.Folder] = append(files[o.Folder], f)
+	}
+	opt.Hooks.AlbumDone = func(o engine.AlbumOutcome) {
+		mu.Lock()
+		defer mu.Unlock()
+		albums = append(albums, AlbumResult{
+			Folder:  o.Folder,
+			Album:   o.Album,
+			AlbumID: o.AlbumID,
+			Added:   o.Added,
+			Err:     o.Err,
+		})
+	}
+
+	start := time.Now()
+	sum, runErr := engine.RunSummary(ctx, opt, u.logf)
+	if sum.Start.IsZero() { // failed before the first album
+		sum.Start, sum.End = start, time.Now()
+	}
+
+	res := &Result{Start: sum.Start, End: sum.End, Bytes: sum.Bytes, CacheHits: sum.CacheHits, CacheMisses: sum.CacheMisses}
+	if runErr != nil {
+		errs = append(errs, runErr)
+	}
+	slices.SortFunc(albums, func(a, b AlbumResult) int { return strings.Compare(a.Folder, b.Folder) })
+	for i := range albums {
+		a := &albums[i]
+		a.Files = files[a.Folder]
+		slices.SortFunc(a.Files, func(a, b FileResult) int { return strings.Compare(a.Path, b.Path) })
+		if a.Err != nil {
+			errs = append(errs, &AlbumError{Folder: a.Folder, Album: a.Album, Err: a.Err})
+		}
+		for _, f := range a.Files {
+			res.count(f)
+			if f.Err != nil {
+				errs = append(errs, &FileError{Path: f.Path, Album: a.Album, Err: f.Err})
+			}
+			if f.AfterErr != nil {
+				errs = append(errs, &FileError{Path: f.Path, Album: a.Album, Err: f.AfterErr, After: true})
+			}
+		}
+	}
+	res.Albums = albums
+	return res, errors.Join(errs...)
+}
+
+// wrap adapts the Option-provided hooks to the engine's types.
+func (h hooks) wrap() engine.Hooks {
+	var out engine.Hooks
+	if h.filter != nil {
+		out.Filter = func(f engine.FileInfo) bool { return h.filter(file(f)) }
+	}
+	out.Album = h.album
+	if h.afterUpload != nil {
+		out.AfterUpload = func(ctx context.Context, f engine.FileInfo, a immich.UploadResult) error {
+			return h.afterUpload(ctx, file(f), Asset{ID: a.ID, Duplicate: a.Status == immich.StatusDuplicate})
+		}
+	}
+	return out
+}
+
+// IsMedia reports whether name has one of the photo or video extensions
+// uploaded by default, for filters that narrow or extend the default.
+func IsMedia(name string) bool {
+	return engine.IsMediaFile(name)
+}
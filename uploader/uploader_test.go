@@ -0,0 +1,133 @@
+package uploader_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"immich-uploader/immich/immichtest"
+	"immich-uploader/uploader"
+)
+
+func TestUploadHooks(t *testing.T) {
+	srv := immichtest.NewServer()
+	defer srv.Close()
+	root := t.TempDir()
+	for _, f := range []string{"trip/a.jpg", "trip/b.raw", "trip/notes.txt", "skip/c.jpg", "bad/d.jpg"} {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(f), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		mu   sync.Mutex
+		done []string
+	)
+	u, err := uploader.New(srv.URL,
+		uploader.WithAPIKey(srv.APIKey),
+		uploader.WithChecksumCache(""),
+		uploader.WithSettleTime(0),
+		uploader.WithValidation(true),
+		uploader.WithLogger(t.Logf),
+		uploader.WithFilter(func(f uploader.File) bool {
+			return uploader.IsMedia(f.Path) || strings.HasSuffix(f.Path, ".raw")
+		}),
+		uploader.WithAlbumResolver(func(ctx context.Context, folder string) (string, error) {
+			switch folder {
+			case "skip":
+				return "", nil
+			case "bad":
+				return "", errors.New("no album")
+			}
+			return "Trip 2026", nil
+		}),
+		uploader.WithAfterUpload(func(ctx context.Context, f uploader.File, a uploader.Asset) error {
+			if strings.HasSuffix(f.Path, ".raw") {
+				return errors.New("keep raws")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			done = append(done, filepath.Base(f.Path))
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := u.Upload(context.Background(), root)
+
+	if res.Uploaded != 2 || res.AfterFailed != 1 || res.Failed != 0 {
+		t.Errorf("result = %+v, want 2 uploaded, 1 failed after upload", res)
+	}
+	if fmt.Sprint(done) != "[a.jpg]" {
+		t.Errorf("after upload ran for %v, want [a.jpg]", done)
+	}
+	var albums []string
+	for _, a := range res.Albums {
+		albums = append(albums, fmt.Sprintf("%s=%q/%d", a.Folder, a.Album, len(a.Files)))
+	}
+	if got := strings.Join(albums, " "); got != `bad=""/0 skip=""/0 trip="Trip 2026"/2` {
+		t.Errorf("albums = %s", got)
+	}
+	if a, ok := srv.Album("Trip 2026"); !ok || len(a.AssetIDs) != 2 {
+		t.Errorf("album Trip 2026 = %+v, want 2 assets", a)
+	}
+	if _, err := os.Stat(filepath.Join(root, "ignore")); !os.IsNotExist(err) {
+		t.Errorf("ignore folder created with WithAfterUpload: %v", err)
+	}
+
+	var ae *uploader.AlbumError
+	if !errors.As(err, &ae) || ae.Folder != "bad" {
+		t.Errorf("err = %v, want an AlbumError for bad", err)
+	}
+	var fe *uploader.FileError
+	if !errors.As(err, &fe) || !fe.After || filepath.Base(fe.Path) != "b.raw" {
+		t.Errorf("err = %v, want an after-upload FileError for b.raw", err)
+	}
+}
+
+func TestUploadTypedErrors(t *testing.T) {
+	srv := immichtest.NewServer()
+	defer srv.Close()
+	srv.Inject(immichtest.Fault{Method: "POST", Path: "/assets", Status: 500, Times: 1})
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "A"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "A", "x.jpg"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := uploader.New(srv.URL, uploader.WithAPIKey(srv.APIKey), uploader.WithChecksumCache(""), uploader.WithSettleTime(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := u.Upload(context.Background(), root)
+	if res.Failed != 1 || !errors.Is(err, uploader.ErrServer) {
+		t.Errorf("result = %+v, err = %v; want 1 failed file with ErrServer", res, err)
+	}
+
+	u, err = uploader.New(srv.URL, uploader.WithAPIKey("wrong"), uploader.WithChecksumCache(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Upload(context.Background(), root); !errors.Is(err, uploader.ErrAuth) {
+		t.Errorf("wrong key: %v, want ErrAuth", err)
+	}
+
+	if _, err := uploader.New(srv.URL); err == nil {
+		t.Error("New without credentials succeeded")
+	}
+	if _, err := uploader.New(srv.URL, uploader.WithAPIKey("k"), uploader.WithWorkers(0)); err == nil {
+		t.Error("New with 0 workers succeeded")
+	}
+}